@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/audit"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"time"
+)
+
+func (cfg *apiConfig) exportUserHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	export, err := cfg.db.ExportUser(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error exporting user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.recordAudit(r, audit.Entry{
+		Action: audit.ActionAccountExport,
+		ActorID: userID,
+		Outcome: audit.OutcomeSuccess,
+	})
+
+	msg, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chirpy-export-%d.json\"", userID))
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+// deleteUserHandler schedules the caller's account for deletion. Refresh
+// tokens are revoked immediately; logging in again before the grace period
+// ends cancels the deletion.
+func (cfg *apiConfig) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	user, err := cfg.db.ScheduleUserDeletion(userID, time.Now().Add(cfg.deletionGracePeriod))
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error scheduling user deletion: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.recordAudit(r, audit.Entry{
+		Action: audit.ActionAccountDelete,
+		ActorID: userID,
+		Outcome: audit.OutcomeSuccess,
+		Detail: "scheduled for " + user.DeletionScheduledFor.Format(time.RFC3339),
+	})
+
+	type deletionResponse struct {
+		ID int `json:"id"`
+		DeletionScheduledFor *time.Time `json:"deletion_scheduled_for"`
+	}
+
+	msg, err := json.Marshal(deletionResponse{ID: user.ID, DeletionScheduledFor: user.DeletionScheduledFor})
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(202)
+	w.Write(msg)
+}
+
+// runAccountPurger periodically purges accounts whose grace period is over.
+func (cfg *apiConfig) runAccountPurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := cfg.db.PurgeDeletedUsers(time.Now(), cfg.chirpDeletionPolicy)
+		if err != nil {
+			fmt.Printf("Error purging deleted users: %s\n", err)
+			continue
+		}
+		for _, id := range purged {
+			fmt.Printf("Purged user %d\n", id)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/audit"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// recordAudit fills in the request metadata and appends entry to the audit
+// log. Failures are logged but never fail the request being audited.
+func (cfg *apiConfig) recordAudit(r *http.Request, entry audit.Entry) {
+	entry.IP = clientIP(r)
+	entry.UserAgent = r.UserAgent()
+
+	if err := cfg.auditLog.Record(entry); err != nil {
+		fmt.Printf("Error writing audit log: %s", err)
+	}
+}
+
+func (cfg *apiConfig) getAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := audit.Filter{
+		Action: query.Get("action"),
+		Outcome: query.Get("outcome"),
+		IP: query.Get("ip"),
+		Limit: 50,
+	}
+
+	intParams := map[string]*int{
+		"actor_id": &filter.ActorID,
+		"target_id": &filter.TargetID,
+		"limit": &filter.Limit,
+		"offset": &filter.Offset,
+	}
+	for name, dest := range intParams {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondWithError(w, 400, fmt.Sprintf("%s must be a non-negative integer", name))
+			return
+		}
+		*dest = n
+	}
+	if filter.Limit == 0 || filter.Limit > 500 {
+		filter.Limit = 500
+	}
+
+	timeParams := map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	}
+	for name, dest := range timeParams {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, 400, fmt.Sprintf("%s must be an RFC 3339 timestamp", name))
+			return
+		}
+		*dest = t
+	}
+
+	entries, total, err := cfg.auditLog.Query(filter)
+	if err != nil {
+		fmt.Printf("Error querying audit log: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type auditResponse struct {
+		Entries []audit.Entry `json:"entries"`
+		Total int `json:"total"`
+		Limit int `json:"limit"`
+		Offset int `json:"offset"`
+	}
+
+	msg, err := json.Marshal(auditResponse{
+		Entries: entries,
+		Total: total,
+		Limit: filter.Limit,
+		Offset: filter.Offset,
+	})
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) runAuditRetention(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := cfg.auditLog.Prune(time.Now().Add(-cfg.auditRetention))
+		if err != nil {
+			fmt.Printf("Error pruning audit log: %s\n", err)
+			continue
+		}
+		if removed > 0 {
+			fmt.Printf("Pruned %d audit log entries\n", removed)
+		}
+	}
+}
+
+// middlewareHideFiles keeps server-side data files that live in the static
+// root from being served by the file server.
+func middlewareHideFiles(next http.Handler, paths ...string) http.Handler {
+	hidden := make(map[string]bool)
+	for _, p := range paths {
+		if abs, err := filepath.Abs(p); err == nil {
+			hidden[abs] = true
+		}
+	}
+
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			abs, err := filepath.Abs("." + filepath.FromSlash(filepath.Clean("/"+r.URL.Path)))
+			if err != nil || hidden[abs] {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+}
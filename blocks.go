@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+)
+
+func (cfg *apiConfig) blockHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setRelationHandler(w, r, cfg.db.BlockUser)
+}
+
+func (cfg *apiConfig) unblockHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setRelationHandler(w, r, cfg.db.UnblockUser)
+}
+
+func (cfg *apiConfig) muteHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setRelationHandler(w, r, cfg.db.MuteUser)
+}
+
+func (cfg *apiConfig) unmuteHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setRelationHandler(w, r, cfg.db.UnmuteUser)
+}
+
+func (cfg *apiConfig) setRelationHandler(w http.ResponseWriter, r *http.Request, update func(int, int) error) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	err = update(userID, targetID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrSelfBlock) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating user relation: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) getBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.getRelationsHandler(w, r, cfg.db.GetBlockedUsers)
+}
+
+func (cfg *apiConfig) getMutesHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.getRelationsHandler(w, r, cfg.db.GetMutedUsers)
+}
+
+func (cfg *apiConfig) getRelationsHandler(w http.ResponseWriter, r *http.Request, list func(int) ([]database.Relation, error)) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	relations, err := list(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(401)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting user relations: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(relations)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+)
+
+func (cfg *apiConfig) editChirpHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+
+	type editParams struct {
+		Body string `json:"body"`
+	}
+	params := editParams{}
+
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	body, err := cleanChirpBody(params.Body)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	chirp, err := cfg.db.EditChirp(userID, chirpID, body, cfg.chirpEditWindow)
+	if errors.Is(err, database.ErrChirpID) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrAuthorization) || errors.Is(err, database.ErrEditWindowClosed) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error editing chirp: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(chirp)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) getChirpHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	chirp, err := cfg.db.GetChirpByID(r.PathValue("chirpID"), viewerID)
+	if errors.Is(err, database.ErrChirpID) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error retrieving chirp: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	revisions, err := cfg.db.GetChirpRevisions(chirpID)
+	if err != nil {
+		fmt.Printf("Error retrieving chirp revisions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type historyResponse struct {
+		Chirp database.Chirp `json:"chirp"`
+		Revisions []database.ChirpRevision `json:"revisions"`
+	}
+
+	msg, err := json.Marshal(historyResponse{Chirp: chirp, Revisions: revisions})
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
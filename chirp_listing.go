@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultChirpListLimit = 50
+	maxChirpListLimit = 100
+)
+
+type paramErrorReturnVal struct {
+	Error string `json:"error"`
+	Details map[string]string `json:"details"`
+}
+
+// respondWithParamErrors reports every invalid query parameter at once, keyed
+// by parameter name.
+func respondWithParamErrors(w http.ResponseWriter, details map[string]string) {
+	msg, err := json.Marshal(paramErrorReturnVal{Error: "Invalid query parameters", Details: details})
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(400)
+	w.Write(msg)
+}
+
+// parseChirpFilter reads the GET /api/chirps query. author_id may be repeated
+// or comma separated; cursor is a token from a previous Link header.
+func parseChirpFilter(r *http.Request) (database.ChirpFilter, map[string]string) {
+	query := r.URL.Query()
+	filter := database.ChirpFilter{
+		Contains: query.Get("contains"),
+		Limit: defaultChirpListLimit,
+	}
+	details := make(map[string]string)
+
+	for _, value := range query["author_id"] {
+		for _, part := range strings.Split(value, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || id < 0 {
+				details["author_id"] = "must be a comma separated list of user IDs"
+				break
+			}
+			filter.AuthorIDs = append(filter.AuthorIDs, id)
+		}
+	}
+
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		details["sort"] = `must be "asc" or "desc"`
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxChirpListLimit {
+			details["limit"] = fmt.Sprintf("must be between 1 and %d", maxChirpListLimit)
+		}
+		filter.Limit = limit
+	}
+
+	timeParams := map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	}
+	for name, dest := range timeParams {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			details[name] = "must be an RFC 3339 timestamp"
+			continue
+		}
+		*dest = t
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && !filter.Since.Before(filter.Until) {
+		details["until"] = "must be later than since"
+	}
+
+	if value := query.Get("has_replies"); value != "" {
+		hasReplies, err := strconv.ParseBool(value)
+		if err != nil {
+			details["has_replies"] = "must be true or false"
+		}
+		filter.HasReplies = &hasReplies
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		direction, id, err := decodeDirectedCursor(value)
+		switch {
+		case err != nil:
+			details["cursor"] = err.Error()
+		case direction == "n":
+			filter.After = id
+		case direction == "p":
+			filter.Before = id
+		default:
+			details["cursor"] = "invalid cursor"
+		}
+	}
+
+	return filter, details
+}
+
+// chirpListLinks builds an RFC 8288 Link header pointing at the pages either
+// side of list. Every other query parameter is carried over unchanged.
+func chirpListLinks(r *http.Request, list database.ChirpList) string {
+	if len(list.Chirps) == 0 {
+		return ""
+	}
+
+	link := func(cursor string, rel string) string {
+		query := r.URL.Query()
+		query.Set("cursor", cursor)
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+	}
+
+	links := []string{}
+	if list.HasNext {
+		links = append(links, link(encodeDirectedCursor("n", list.Chirps[len(list.Chirps)-1].ID), "next"))
+	}
+	if list.HasPrev {
+		links = append(links, link(encodeDirectedCursor("p", list.Chirps[0].ID), "prev"))
+	}
+
+	return strings.Join(links, ", ")
+}
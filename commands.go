@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"internal/database"
+)
+
+// runCommand runs a one-off administrative command against the database
+// instead of starting the server, and returns the exit status. Admins are
+// granted here, by whoever runs the server, rather than at signup where
+// anyone can claim any email address.
+func runCommand(db *database.DB, args []string) int {
+	switch args[0] {
+	case "grant-admin":
+		if len(args) != 2 {
+			fmt.Println("usage: chirpy grant-admin <email>")
+			return 2
+		}
+		return grantAdmin(db, args[1])
+	default:
+		fmt.Printf("unknown command %q\n", args[0])
+		fmt.Println("usage: chirpy [grant-admin <email>]")
+		return 2
+	}
+}
+
+// grantAdmin gives the account registered with email the admin role. The
+// account has to exist, so the admin signs up first.
+func grantAdmin(db *database.DB, email string) int {
+	user, err := db.GetUserByEmail(email)
+	if errors.Is(err, database.ErrUserNotFound) {
+		fmt.Printf("No user is registered with %s\n", email)
+		return 1
+	}
+	if err != nil {
+		fmt.Printf("Error getting user: %s\n", err)
+		return 1
+	}
+
+	if _, err := db.UpdateUserRole(user.ID, database.RoleAdmin); err != nil {
+		fmt.Printf("Error updating user role: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("User %d (%s) is now an admin\n", user.ID, user.Email)
+	return 0
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+type draftParameters struct {
+	Body string `json:"body"`
+	InReplyTo int `json:"in_reply_to"`
+	QuoteOf int `json:"quote_of"`
+	MediaIDs []int `json:"media_ids"`
+	Poll *pollParameters `json:"poll"`
+	Visibility string `json:"visibility"`
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+// draft checks everything that can be checked before publishing, so most
+// problems surface while the author is still around to fix them.
+func (p draftParameters) draft(userID int, now time.Time) (database.Draft, error) {
+	if _, err := cleanChirpBody(p.Body); err != nil {
+		return database.Draft{}, err
+	}
+
+	draft := database.Draft{
+		AuthorID: userID,
+		Body: p.Body,
+		InReplyTo: p.InReplyTo,
+		QuoteOf: p.QuoteOf,
+		MediaIDs: p.MediaIDs,
+		Visibility: p.Visibility,
+		PublishAt: p.PublishAt,
+	}
+
+	if p.Poll != nil {
+		if _, err := p.Poll.options(now); err != nil {
+			return database.Draft{}, err
+		}
+		draft.Poll = &database.DraftPoll{Options: p.Poll.Options, DurationMinutes: p.Poll.DurationMinutes}
+	}
+
+	if p.PublishAt != nil && (!p.PublishAt.After(now) || p.PublishAt.Sub(now) > maxScheduleAhead) {
+		return database.Draft{}, errors.New("publish_at must be in the future and at most a year away")
+	}
+
+	return draft, nil
+}
+
+// publishDraft runs a draft through the same cleaning as POST /api/chirps
+// and publishes it.
+func (cfg *apiConfig) publishDraft(draft database.Draft) (database.Chirp, error) {
+	body, err := cleanChirpBody(draft.Body)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+
+	opts := database.ChirpOptions{
+		InReplyTo: draft.InReplyTo,
+		QuoteOf: draft.QuoteOf,
+		MediaIDs: draft.MediaIDs,
+		Visibility: draft.Visibility,
+	}
+	if draft.Poll != nil {
+		poll := pollParameters{Options: draft.Poll.Options, DurationMinutes: draft.Poll.DurationMinutes}
+		opts.Poll, err = poll.options(time.Now())
+		if err != nil {
+			return database.Chirp{}, err
+		}
+	}
+
+	return cfg.db.PublishDraft(draft, body, opts)
+}
+
+func (cfg *apiConfig) createDraftHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.saveDraftHandler(w, r, 0)
+}
+
+func (cfg *apiConfig) updateDraftHandler(w http.ResponseWriter, r *http.Request) {
+	draftID, err := strconv.Atoi(r.PathValue("draftID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	cfg.saveDraftHandler(w, r, draftID)
+}
+
+func (cfg *apiConfig) saveDraftHandler(w http.ResponseWriter, r *http.Request, draftID int) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	params := draftParameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid JSON body")
+		return
+	}
+
+	draft, err := params.draft(userID, time.Now())
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	draft.ID = draftID
+
+	draft, err = cfg.db.SaveDraft(draft)
+	if errors.Is(err, database.ErrDraftNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrMediaUnavailable) || errors.Is(err, database.ErrTooManyMedia) || errors.Is(err, database.ErrInvalidVisibility) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrTooManyDrafts) {
+		respondWithError(w, 409, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(401)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error saving draft: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(draft)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if draftID == 0 {
+		w.WriteHeader(201)
+	} else {
+		w.WriteHeader(200)
+	}
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) getDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	drafts, err := cfg.db.GetDrafts(userID)
+	if err != nil {
+		fmt.Printf("Error getting drafts: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(drafts)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) getDraftHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	draftID, err := strconv.Atoi(r.PathValue("draftID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	draft, err := cfg.db.GetDraft(userID, draftID)
+	if errors.Is(err, database.ErrDraftNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting draft: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(draft)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) deleteDraftHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	draftID, err := strconv.Atoi(r.PathValue("draftID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	err = cfg.db.DeleteDraft(userID, draftID)
+	if errors.Is(err, database.ErrDraftNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error deleting draft: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) publishDraftHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	draftID, err := strconv.Atoi(r.PathValue("draftID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	draft, err := cfg.db.GetDraft(userID, draftID)
+	if errors.Is(err, database.ErrDraftNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting draft: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	chirp, err := cfg.publishDraft(draft)
+	if errors.Is(err, database.ErrDraftNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrDraftChanged) {
+		respondWithError(w, 409, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if draftRejected(err) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error publishing draft: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(chirp)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(201)
+	w.Write(msg)
+}
+
+// draftRejected reports whether err means the draft itself can't be
+// published as it stands, as opposed to a failure worth retrying.
+func draftRejected(err error) bool {
+	for _, target := range []error{
+		errChirpTooLong,
+		database.ErrReplyTarget,
+		database.ErrQuoteTarget,
+		database.ErrBlocked,
+		database.ErrMediaUnavailable,
+		database.ErrTooManyMedia,
+		database.ErrInvalidPoll,
+		errPollDuration,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// runScheduler publishes scheduled drafts as they fall due. It runs once
+// straight away so anything that came due while the server was down goes
+// out on startup; drafts more than maxDelay late are left unpublished
+// instead, since their content may no longer be wanted.
+func (cfg *apiConfig) runScheduler(interval time.Duration, maxDelay time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		cfg.publishDueDrafts(time.Now(), maxDelay)
+		<-ticker.C
+	}
+}
+
+func (cfg *apiConfig) publishDueDrafts(now time.Time, maxDelay time.Duration) {
+	drafts, err := cfg.db.GetDueDrafts(now)
+	if err != nil {
+		fmt.Printf("Error getting due drafts: %s\n", err)
+		return
+	}
+
+	for _, draft := range drafts {
+		if late := now.Sub(*draft.PublishAt); late > maxDelay {
+			reason := fmt.Sprintf("Missed its scheduled time by %s", late.Round(time.Minute))
+			cfg.failDraft(draft, reason)
+			continue
+		}
+
+		_, err := cfg.publishDraft(draft)
+		if errors.Is(err, database.ErrDraftChanged) || errors.Is(err, database.ErrDraftNotFound) {
+			// edited or deleted since it was read; the next run sees the new state
+			continue
+		}
+		if draftRejected(err) {
+			cfg.failDraft(draft, err.Error())
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Error publishing draft %d: %s\n", draft.ID, err)
+		}
+	}
+}
+
+// failDraft unschedules a draft the scheduler gave up on. A draft edited or
+// deleted in the meantime is left alone for the next run to look at.
+func (cfg *apiConfig) failDraft(draft database.Draft, reason string) {
+	err := cfg.db.FailDraft(draft, reason)
+	if errors.Is(err, database.ErrDraftChanged) || errors.Is(err, database.ErrDraftNotFound) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error unscheduling draft %d: %s\n", draft.ID, err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultTimelineLimit = 20
+	maxTimelineLimit = 100
+)
+
+// encodeCursor turns a chirp ID into an opaque pagination token so clients
+// don't come to rely on its contents.
+func encodeCursor(id int) string {
+	return encodeDirectedCursor("c", id)
+}
+
+func decodeCursor(cursor string) (int, error) {
+	direction, id, err := decodeDirectedCursor(cursor)
+	if err != nil || direction != "c" {
+		return 0, errors.New("invalid cursor")
+	}
+	return id, nil
+}
+
+// encodeDirectedCursor is encodeCursor for listings that page both ways;
+// direction says which side of the chirp the requested page lies on.
+func encodeDirectedCursor(direction string, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(direction + ":" + strconv.Itoa(id)))
+}
+
+func decodeDirectedCursor(cursor string) (string, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, errors.New("invalid cursor")
+	}
+	direction, idString, ok := strings.Cut(string(raw), ":")
+	id, err := strconv.Atoi(idString)
+	if !ok || err != nil || id <= 0 {
+		return "", 0, errors.New("invalid cursor")
+	}
+	return direction, id, nil
+}
+
+func (cfg *apiConfig) followHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollowHandler(w, r, true)
+}
+
+func (cfg *apiConfig) unfollowHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollowHandler(w, r, false)
+}
+
+func (cfg *apiConfig) setFollowHandler(w http.ResponseWriter, r *http.Request, follow bool) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	targetID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	if follow {
+		err = cfg.db.FollowUser(userID, targetID)
+	} else {
+		err = cfg.db.UnfollowUser(userID, targetID)
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrSelfFollow) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating follow: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) getFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.getFollowsHandler(w, r, cfg.db.GetFollowers)
+}
+
+func (cfg *apiConfig) getFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.getFollowsHandler(w, r, cfg.db.GetFollowing)
+}
+
+func (cfg *apiConfig) getFollowsHandler(w http.ResponseWriter, r *http.Request, list func(int) ([]database.Relation, error)) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	follows, err := list(userID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting follows: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(follows)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+type chirpPage struct {
+	Chirps []database.Chirp `json:"chirps"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parsePageParams reads the limit and cursor query parameters shared by the
+// newest-first chirp feeds.
+func parsePageParams(r *http.Request) (before int, limit int, err error) {
+	limit = defaultTimelineLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxTimelineLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxTimelineLimit)
+		}
+	}
+
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		before, err = decodeCursor(value)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return before, limit, nil
+}
+
+// newChirpPage trims a result fetched with limit+1 rows down to limit and
+// sets the cursor when there is more to read.
+func newChirpPage(chirps []database.Chirp, limit int) chirpPage {
+	page := chirpPage{Chirps: chirps}
+	if len(chirps) > limit {
+		page.Chirps = chirps[:limit]
+		page.NextCursor = encodeCursor(page.Chirps[limit-1].ID)
+	}
+	return page
+}
+
+func (cfg *apiConfig) getTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	before, limit, err := parsePageParams(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	// fetch one extra to know whether another page exists
+	chirps, err := cfg.db.GetTimeline(userID, before, limit+1)
+	if err != nil {
+		fmt.Printf("Error getting timeline: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	page := newChirpPage(chirps, limit)
+
+	if err := cfg.db.SetViewerState(userID, page.Chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(page)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
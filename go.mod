@@ -6,12 +6,48 @@ require internal/database v1.0.0
 
 replace internal/database => ./internal/database
 
-require internal/auth v1.0.0
+require (
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.26.0
+	internal/auth v1.0.0
+)
 
 replace internal/auth => ./internal/auth
 
+require internal/password v1.0.0
+
+replace internal/password => ./internal/password
+
+require internal/audit v1.0.0
+
+replace internal/audit => ./internal/audit
+
+require internal/entities v1.0.0
+
+replace internal/entities => ./internal/entities
+
+require internal/search v1.0.0
+
+replace internal/search => ./internal/search
+
+require internal/trending v1.0.0
+
+replace internal/trending => ./internal/trending
+
+require internal/media v1.0.0
+
+replace internal/media => ./internal/media
+
+require internal/stream v1.0.0
+
+replace internal/stream => ./internal/stream
+
+require internal/websocket v1.0.0
+
+replace internal/websocket => ./internal/websocket
+
 require (
 	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
 )
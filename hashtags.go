@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func (cfg *apiConfig) getHashtagChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	before, limit, err := parsePageParams(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	chirps, err := cfg.db.GetHashtagChirps(r.PathValue("tag"), viewerID, before, limit+1)
+	if err != nil {
+		fmt.Printf("Error getting hashtag chirps: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	page := newChirpPage(chirps, limit)
+
+	if err := cfg.db.SetViewerState(viewerID, page.Chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(page)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
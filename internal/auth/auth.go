@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -18,23 +19,79 @@ func ParseBearerToken(header string) (string, error) {
 	return header, nil
 }
 
+var ErrForbidden = errors.New("Insufficient role")
+
+var roleRanks = map[string]int{
+	"user": 1,
+	"moderator": 2,
+	"admin": 3,
+}
+
+type Claims struct {
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Identity is the authenticated caller described by a validated access token.
+// ExpiresAt is zero for tokens that never expire.
+type Identity struct {
+	UserID int
+	Role string
+	ExpiresAt time.Time
+}
+
 func ParseUserIDFromJWT(jsonWebToken string) (int, error) {
-	token, err := jwt.ParseWithClaims(jsonWebToken, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+	identity, err := ParseIdentityFromJWT(jsonWebToken)
+	if err != nil {
+		return 0, err
+	}
+
+	return identity.UserID, nil
+}
+
+func ParseIdentityFromJWT(jsonWebToken string) (Identity, error) {
+	claims := Claims{}
+	_, err := jwt.ParseWithClaims(jsonWebToken, &claims, func(token *jwt.Token) (interface{}, error) {
     return []byte(os.Getenv("JWT_SECRET_KEY")), nil
 	})
 	if err != nil {
-		return 0, err
+		return Identity{}, err
 	}
 
-	userID, err := token.Claims.GetSubject()
+	userID, err := claims.GetSubject()
 	if err != nil {
-		return 0, err
+		return Identity{}, err
 	}
 
 	ID, err := strconv.Atoi(userID)
 	if err != nil {
-		return 0, err
+		return Identity{}, err
+	}
+
+	role := claims.Role
+	if role == "" {
+		role = "user"
+	}
+
+	identity := Identity{UserID: ID, Role: role}
+	if claims.ExpiresAt != nil {
+		identity.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	return identity, nil
+}
+
+// HasRole reports whether role grants at least the privileges of required.
+// Unknown roles grant nothing.
+func HasRole(role string, required string) bool {
+	rank, ok := roleRanks[role]
+	if !ok {
+		return false
 	}
+	return rank >= roleRanks[required]
+}
 
-	return ID, nil
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
 }
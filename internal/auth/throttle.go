@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const maxTrackedKeys = 100000
+
+// LoginThrottle tracks failed login attempts per key (an account or an IP)
+// and applies exponential backoff followed by a temporary lockout.
+type LoginThrottle struct {
+	mutex sync.Mutex
+	attempts map[string]*loginAttempts
+
+	FreeAttempts int
+	BaseDelay time.Duration
+	MaxDelay time.Duration
+	LockoutThreshold int
+	LockoutDuration time.Duration
+	ResetAfter time.Duration
+
+	Now func() time.Time
+}
+
+type loginAttempts struct {
+	failures int
+	lastFailure time.Time
+	blockedUntil time.Time
+}
+
+// ThrottleResult describes the state of a key after a failed attempt.
+type ThrottleResult struct {
+	Failures int
+	BlockedUntil time.Time
+	Locked bool
+}
+
+func NewLoginThrottle(freeAttempts int, lockoutThreshold int, lockoutDuration time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		attempts: make(map[string]*loginAttempts),
+		FreeAttempts: freeAttempts,
+		BaseDelay: time.Second,
+		MaxDelay: 5 * time.Minute,
+		LockoutThreshold: lockoutThreshold,
+		LockoutDuration: lockoutDuration,
+		ResetAfter: 24 * time.Hour,
+		Now: time.Now,
+	}
+}
+
+// Blocked reports how long the caller must wait before key may attempt
+// another login. A zero duration means the attempt is allowed.
+func (t *LoginThrottle) Blocked(key string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	a, ok := t.attempts[key]
+	if !ok {
+		return 0
+	}
+
+	now := t.Now()
+	if now.Sub(a.lastFailure) > t.ResetAfter {
+		delete(t.attempts, key)
+		return 0
+	}
+
+	if now.Before(a.blockedUntil) {
+		return a.blockedUntil.Sub(now)
+	}
+	return 0
+}
+
+// RecordFailure registers a failed attempt for key and returns the new state.
+func (t *LoginThrottle) RecordFailure(key string) ThrottleResult {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.Now()
+	t.prune(now)
+
+	a, ok := t.attempts[key]
+	if !ok || now.Sub(a.lastFailure) > t.ResetAfter {
+		a = &loginAttempts{}
+		t.attempts[key] = a
+	}
+
+	a.failures++
+	a.lastFailure = now
+
+	result := ThrottleResult{Failures: a.failures}
+
+	if a.failures >= t.LockoutThreshold {
+		a.blockedUntil = now.Add(t.LockoutDuration)
+		// the lockout is served in full, after which the count starts over
+		a.failures = 0
+		result.Locked = true
+	} else if a.failures > t.FreeAttempts {
+		delay := t.BaseDelay << (a.failures - t.FreeAttempts - 1)
+		if delay > t.MaxDelay || delay <= 0 {
+			delay = t.MaxDelay
+		}
+		a.blockedUntil = now.Add(delay)
+	}
+
+	result.BlockedUntil = a.blockedUntil
+	return result
+}
+
+// RecordSuccess clears any failures recorded against key.
+func (t *LoginThrottle) RecordSuccess(key string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.attempts, key)
+}
+
+// prune keeps the map bounded under a spray of unique keys. Stale entries go
+// first; if that is not enough, anything not currently blocked is dropped.
+// Caller must hold the mutex.
+func (t *LoginThrottle) prune(now time.Time) {
+	if len(t.attempts) < maxTrackedKeys {
+		return
+	}
+
+	for key, a := range t.attempts {
+		if now.After(a.blockedUntil) && now.Sub(a.lastFailure) > t.ResetAfter {
+			delete(t.attempts, key)
+		}
+	}
+
+	if len(t.attempts) < maxTrackedKeys {
+		return
+	}
+
+	for key, a := range t.attempts {
+		if now.After(a.blockedUntil) {
+			delete(t.attempts, key)
+		}
+	}
+}
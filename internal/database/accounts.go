@@ -0,0 +1,247 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ChirpPolicyDelete = "delete"
+	ChirpPolicyAnonymize = "anonymize"
+)
+
+// DeletedAuthorID is the author of chirps left behind by a purged account.
+const DeletedAuthorID = 0
+
+var ErrUnknownChirpPolicy = errors.New("unknown chirp deletion policy")
+
+type Session struct {
+	TokenHint string `json:"token_hint"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type UserExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	Profile User `json:"profile"`
+	Chirps []Chirp `json:"chirps"`
+	ChirpRevisions map[int][]ChirpRevision `json:"chirp_revisions"`
+	Media []Media `json:"media"`
+	Drafts []Draft `json:"drafts"`
+	Messages []Message `json:"messages"`
+	Notifications []Notification `json:"notifications"`
+	Lists []List `json:"lists"`
+	Sessions []Session `json:"sessions"`
+}
+
+// ExportUser collects everything stored about a user. The password hash is
+// never included.
+func (db *DB) ExportUser(userID int) (UserExport, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return UserExport{}, err
+	}
+
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return UserExport{}, ErrUserNotFound
+	}
+	user.Password = ""
+
+	export := UserExport{
+		ExportedAt: time.Now(),
+		Profile: user,
+		Chirps: []Chirp{},
+		ChirpRevisions: make(map[int][]ChirpRevision),
+		Media: []Media{},
+		Drafts: userDrafts(dbStructure, userID),
+		Messages: userMessages(dbStructure, userID),
+		Notifications: append([]Notification{}, dbStructure.Notifications[userID]...),
+		Lists: userLists(dbStructure, userID),
+		Sessions: []Session{},
+	}
+
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == userID {
+			export.Chirps = append(export.Chirps, chirp)
+			if revisions, ok := dbStructure.ChirpRevisions[chirp.ID]; ok {
+				export.ChirpRevisions[chirp.ID] = revisions
+			}
+		}
+	}
+
+	for _, m := range dbStructure.Media {
+		if m.OwnerID == userID {
+			export.Media = append(export.Media, m)
+		}
+	}
+
+	for token, refreshToken := range dbStructure.RefreshTokens {
+		if refreshToken.UserID == userID {
+			export.Sessions = append(export.Sessions, Session{
+				TokenHint: token[:8],
+				ExpiresAt: refreshToken.ExpiresAt,
+			})
+		}
+	}
+
+	return export, nil
+}
+
+// ScheduleUserDeletion revokes every refresh token the user holds and marks
+// the account for purging once purgeAt has passed.
+func (db *DB) ScheduleUserDeletion(userID int, purgeAt time.Time) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrUserNotFound
+		}
+
+		user.DeletionScheduledFor = &purgeAt
+		dbStructure.Users[userID] = user
+
+		for token, refreshToken := range dbStructure.RefreshTokens {
+			if refreshToken.UserID == userID {
+				delete(dbStructure.RefreshTokens, token)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (db *DB) CancelUserDeletion(userID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[userID]
+		if !ok {
+			return ErrUserNotFound
+		}
+
+		user.DeletionScheduledFor = nil
+		dbStructure.Users[userID] = user
+		return nil
+	})
+}
+
+// PurgeDeletedUsers removes every account whose grace period ended before
+// now. Their chirps are deleted or reassigned to DeletedAuthorID depending on
+// chirpPolicy. It returns the IDs of the purged users.
+func (db *DB) PurgeDeletedUsers(now time.Time, chirpPolicy string) ([]int, error) {
+	if chirpPolicy != ChirpPolicyDelete && chirpPolicy != ChirpPolicyAnonymize {
+		return nil, ErrUnknownChirpPolicy
+	}
+
+	purged := []int{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for id, user := range dbStructure.Users {
+			if user.DeletionScheduledFor == nil || user.DeletionScheduledFor.After(now) {
+				continue
+			}
+			purgeUser(dbStructure, id, chirpPolicy)
+			purged = append(purged, id)
+		}
+
+		if len(purged) == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return purged, nil
+}
+
+func purgeUser(dbStructure *DBStructure, userID int, chirpPolicy string) {
+	authored := []int{}
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == userID {
+			authored = append(authored, id)
+		}
+	}
+
+	// removing one chirp can take others with it, so look each one up again
+	for _, id := range authored {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok {
+			continue
+		}
+		// a rechirp has no content of its own to keep
+		if chirpPolicy == ChirpPolicyDelete || chirp.RechirpOf != 0 {
+			removeChirp(dbStructure, id)
+		} else {
+			unindexChirp(dbStructure, chirp)
+			chirp.AuthorID = DeletedAuthorID
+			insertChirp(dbStructure, chirp)
+			dbStructure.emit(ChirpUpdated, chirp, 0)
+		}
+	}
+
+	forgetVoter(dbStructure, userID)
+	leaveConversations(dbStructure, userID)
+	forgetNotifications(dbStructure, userID)
+	forgetLists(dbStructure, userID)
+
+	for id, draft := range dbStructure.Drafts {
+		if draft.AuthorID == userID {
+			delete(dbStructure.Drafts, id)
+		}
+	}
+
+	// attached media stays with anonymized chirps; the rest goes
+	for id, m := range dbStructure.Media {
+		if m.OwnerID != userID {
+			continue
+		}
+		if m.ChirpID == 0 {
+			delete(dbStructure.Media, id)
+		} else {
+			m.OwnerID = DeletedAuthorID
+			dbStructure.Media[id] = m
+		}
+	}
+
+	for followee := range dbStructure.Following[userID] {
+		delete(dbStructure.Followers[followee], userID)
+	}
+	for follower := range dbStructure.Followers[userID] {
+		delete(dbStructure.Following[follower], userID)
+	}
+	for _, graph := range []map[int]map[int]time.Time{dbStructure.Blocks, dbStructure.Mutes} {
+		delete(graph, userID)
+		for _, targets := range graph {
+			delete(targets, userID)
+		}
+	}
+
+	delete(dbStructure.Following, userID)
+	delete(dbStructure.Followers, userID)
+	delete(dbStructure.AuthorIndex, userID)
+
+	for token, refreshToken := range dbStructure.RefreshTokens {
+		if refreshToken.UserID == userID {
+			delete(dbStructure.RefreshTokens, token)
+		}
+	}
+
+	for chirpID, likers := range dbStructure.Likes {
+		if _, ok := likers[userID]; !ok {
+			continue
+		}
+		delete(likers, userID)
+		if chirp, ok := dbStructure.Chirps[chirpID]; ok {
+			chirp.LikeCount = len(likers)
+			dbStructure.Chirps[chirpID] = chirp
+		}
+	}
+
+	delete(dbStructure.Users, userID)
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrBlocked = errors.New("Action not allowed between these users")
+var ErrSelfBlock = errors.New("Users cannot block or mute themselves")
+
+// BlockUser stops blockerID and blockedID from following, replying to,
+// liking, rechirping or mentioning each other, and removes any existing
+// follows between them and takes each off the other's lists.
+func (db *DB) BlockUser(blockerID int, blockedID int) error {
+	return db.setRelation(blockerID, blockedID, true, func(d *DBStructure) map[int]map[int]time.Time {
+		delete(d.Following[blockerID], blockedID)
+		delete(d.Followers[blockedID], blockerID)
+		delete(d.Following[blockedID], blockerID)
+		delete(d.Followers[blockerID], blockedID)
+		unlistBlocked(d, blockerID, blockedID)
+		return d.Blocks
+	})
+}
+
+func (db *DB) UnblockUser(blockerID int, blockedID int) error {
+	return db.setRelation(blockerID, blockedID, false, func(d *DBStructure) map[int]map[int]time.Time { return d.Blocks })
+}
+
+// MuteUser hides mutedID's chirps from muterID's lists, timelines and
+// searches without telling mutedID or restricting them in any other way.
+func (db *DB) MuteUser(muterID int, mutedID int) error {
+	return db.setRelation(muterID, mutedID, true, func(d *DBStructure) map[int]map[int]time.Time { return d.Mutes })
+}
+
+func (db *DB) UnmuteUser(muterID int, mutedID int) error {
+	return db.setRelation(muterID, mutedID, false, func(d *DBStructure) map[int]map[int]time.Time { return d.Mutes })
+}
+
+func (db *DB) GetBlockedUsers(userID int) ([]Relation, error) {
+	return db.getRelations(userID, func(d *DBStructure) map[int]map[int]time.Time { return d.Blocks })
+}
+
+func (db *DB) GetMutedUsers(userID int) ([]Relation, error) {
+	return db.getRelations(userID, func(d *DBStructure) map[int]map[int]time.Time { return d.Mutes })
+}
+
+// HiddenAuthors returns the users whose chirps viewerID should not be shown.
+func (db *DB) HiddenAuthors(viewerID int) (map[int]bool, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	return hiddenAuthors(dbStructure, viewerID), nil
+}
+
+func (db *DB) setRelation(userID int, targetID int, set bool, graph func(*DBStructure) map[int]map[int]time.Time) error {
+	if userID == targetID {
+		return ErrSelfBlock
+	}
+
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[targetID]; !ok && set {
+			return ErrUserNotFound
+		}
+
+		relations := graph(dbStructure)
+		if set {
+			if _, ok := relations[userID][targetID]; ok {
+				return errNoChanges
+			}
+			if relations[userID] == nil {
+				relations[userID] = make(map[int]time.Time)
+			}
+			relations[userID][targetID] = time.Now().UTC()
+		} else {
+			if _, ok := relations[userID][targetID]; !ok {
+				return errNoChanges
+			}
+			delete(relations[userID], targetID)
+		}
+		return nil
+	})
+}
+
+// isBlocked reports whether either user has blocked the other.
+func isBlocked(dbStructure *DBStructure, a int, b int) bool {
+	if a == 0 || b == 0 || a == b {
+		return false
+	}
+	_, ab := dbStructure.Blocks[a][b]
+	_, ba := dbStructure.Blocks[b][a]
+	return ab || ba
+}
+
+// hiddenAuthors is the set of authors whose chirps viewerID should not see
+// in lists, timelines and searches: anyone blocked in either direction and
+// anyone the viewer has muted.
+func hiddenAuthors(dbStructure *DBStructure, viewerID int) map[int]bool {
+	hidden := make(map[int]bool)
+	if viewerID == 0 {
+		return hidden
+	}
+
+	for id := range dbStructure.Blocks[viewerID] {
+		hidden[id] = true
+	}
+	for id := range dbStructure.Mutes[viewerID] {
+		hidden[id] = true
+	}
+	for blocker, blocked := range dbStructure.Blocks {
+		if _, ok := blocked[viewerID]; ok {
+			hidden[blocker] = true
+		}
+	}
+
+	return hidden
+}
+
+// chirpHidden reports whether a chirp, or the original it shares or
+// quotes, was written by a hidden author.
+func chirpHidden(dbStructure *DBStructure, chirp Chirp, hidden map[int]bool) bool {
+	if len(hidden) == 0 {
+		return false
+	}
+	if hidden[chirp.AuthorID] {
+		return true
+	}
+
+	originalID := chirp.RechirpOf
+	if originalID == 0 {
+		originalID = chirp.QuoteOf
+	}
+	if original, ok := dbStructure.Chirps[originalID]; ok && hidden[original.AuthorID] {
+		return true
+	}
+
+	return false
+}
@@ -10,21 +10,47 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"internal/entities"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type DB struct {
 	path string
 	mutex sync.RWMutex
+	updateMutex sync.Mutex
+	listenerMutex sync.RWMutex
+	listeners []func(ChirpEvent)
+	notificationListeners []func(NotificationEvent)
 }
 
 type Chirp struct {
 	ID int `json:"id"`
 	Body string `json:"body"`
 	AuthorID int `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	InReplyTo int `json:"in_reply_to,omitempty"`
+	ReplyCount int `json:"reply_count"`
+	Deleted bool `json:"deleted,omitempty"`
+	LikeCount int `json:"like_count"`
+	Liked *bool `json:"liked,omitempty"`
+	RechirpOf int `json:"rechirp_of,omitempty"`
+	QuoteOf int `json:"quote_of,omitempty"`
+	RechirpCount int `json:"rechirp_count"`
+	QuoteCount int `json:"quote_count"`
+	Original *Chirp `json:"original,omitempty"`
+	OriginalUnavailable bool `json:"original_unavailable,omitempty"`
+	Unavailable bool `json:"unavailable,omitempty"`
+	Entities entities.Entities `json:"entities"`
+	MediaIDs []int `json:"media_ids,omitempty"`
+	Media []Media `json:"media,omitempty"`
+	Poll *Poll `json:"poll,omitempty"`
+	Visibility string `json:"visibility"`
 }
 
 type User struct {
@@ -34,6 +60,28 @@ type User struct {
 	RefreshToken string `json:"refresh_token,omitempty"`
 	Password string `json:"password,omitempty"`
 	IsChirpyRed bool `json:"is_chirpy_red"`
+	Role string `json:"role,omitempty"`
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for,omitempty"`
+	Handle string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio string `json:"bio,omitempty"`
+	AvatarMediaID int `json:"avatar_media_id,omitempty"`
+}
+
+const (
+	RoleUser = "user"
+	RoleModerator = "moderator"
+	RoleAdmin = "admin"
+)
+
+// CanModerate reports whether the user may act on content they did not author.
+func (u User) CanModerate() bool {
+	return u.Role == RoleModerator || u.Role == RoleAdmin
+}
+
+type accessTokenClaims struct {
+	Role string `json:"role"`
+	jwt.RegisteredClaims
 }
 
 type RefreshToken struct {
@@ -45,14 +93,47 @@ type RefreshToken struct {
 type DBStructure struct {
 	Chirps map[int]Chirp `json:"chirps"`
 	Users map[int]User `json:"users"`
+	LastUserID int `json:"last_user_id"`
 	RefreshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	LockoutEvents []LockoutEvent `json:"lockout_events"`
+	ChirpRevisions map[int][]ChirpRevision `json:"chirp_revisions"`
+	Likes map[int]map[int]time.Time `json:"likes"`
+	LastChirpID int `json:"last_chirp_id"`
+	AuthorIndex map[int][]int `json:"author_index"`
+	Following map[int]map[int]time.Time `json:"following"`
+	Followers map[int]map[int]time.Time `json:"followers"`
+	Blocks map[int]map[int]time.Time `json:"blocks"`
+	Mutes map[int]map[int]time.Time `json:"mutes"`
+	HashtagIndex map[string][]int `json:"hashtag_index"`
+	Media map[int]Media `json:"media"`
+	LastMediaID int `json:"last_media_id"`
+	Polls map[int]StoredPoll `json:"polls"`
+	Drafts map[int]Draft `json:"drafts"`
+	LastDraftID int `json:"last_draft_id"`
+	Conversations map[int]Conversation `json:"conversations"`
+	Messages map[int][]Message `json:"messages"`
+	LastConversationID int `json:"last_conversation_id"`
+	LastMessageID int `json:"last_message_id"`
+	Notifications map[int][]Notification `json:"notifications"`
+	NotificationPrefs map[int]map[string]bool `json:"notification_prefs"`
+	LastNotificationID int `json:"last_notification_id"`
+	Lists map[int]List `json:"lists"`
+	LastListID int `json:"last_list_id"`
+
+	pending []ChirpEvent
+	pendingNotifications []NotificationEvent
 }
 
 var ErrChirpID = errors.New("chirp id out of range")
 var ErrAuthorization = errors.New("Unauthorized action")
 var ErrUserNotFound = errors.New("User not found")
+var ErrEmailTaken = errors.New("Email is already registered")
+var ErrReplyTarget = errors.New("Chirp being replied to does not exist")
+var ErrQuoteTarget = errors.New("Chirp being quoted does not exist")
 
-func NewDB(path string) (*DB, error) {
+// NewDB opens the database in path, creating it if needed. Existing data is
+// kept unless reset is set, in which case the database starts out empty.
+func NewDB(path string, reset bool) (*DB, error) {
 	fp := path + "/database.json"
 	
 	db := DB{path: fp}
@@ -63,22 +144,100 @@ func NewDB(path string) (*DB, error) {
 		return nil, err
 	}
 
-	if err := os.Truncate(fp, 0); err != nil {
-		fmt.Println("failed to clear file")
-		return nil, err
+	if reset {
+		if err := os.Truncate(fp, 0); err != nil {
+			fmt.Println("failed to clear file")
+			return nil, err
+		}
 	}
 
-	empty := DBStructure{
-		Chirps: make(map[int]Chirp),
-		Users: make(map[int]User),
-		RefreshTokens: make(map[string]RefreshToken),
+	dbStructure := &DBStructure{}
+	if info, err := os.Stat(fp); err != nil {
+		return nil, err
+	} else if info.Size() > 0 {
+		dbStructure, err = db.LoadDB()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = db.writeDB(empty)
+	// a file written by an older version may lack newer collections, have
+	// users without handles and tombstones that kept too much
+	dbStructure.initCollections()
+	dbStructure.assignMissingHandles()
+	dbStructure.scrubTombstones()
+
+	err = db.writeDB(*dbStructure)
+	if err != nil {
+		return nil, err
+	}
 
 	return &db, nil
 }
 
+func (dbStructure *DBStructure) initCollections() {
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = make(map[int]Chirp)
+	}
+	if dbStructure.Users == nil {
+		dbStructure.Users = make(map[int]User)
+	}
+	if dbStructure.RefreshTokens == nil {
+		dbStructure.RefreshTokens = make(map[string]RefreshToken)
+	}
+	if dbStructure.LockoutEvents == nil {
+		dbStructure.LockoutEvents = []LockoutEvent{}
+	}
+	if dbStructure.ChirpRevisions == nil {
+		dbStructure.ChirpRevisions = make(map[int][]ChirpRevision)
+	}
+	if dbStructure.Likes == nil {
+		dbStructure.Likes = make(map[int]map[int]time.Time)
+	}
+	if dbStructure.AuthorIndex == nil {
+		dbStructure.AuthorIndex = make(map[int][]int)
+	}
+	if dbStructure.Following == nil {
+		dbStructure.Following = make(map[int]map[int]time.Time)
+	}
+	if dbStructure.Followers == nil {
+		dbStructure.Followers = make(map[int]map[int]time.Time)
+	}
+	if dbStructure.Blocks == nil {
+		dbStructure.Blocks = make(map[int]map[int]time.Time)
+	}
+	if dbStructure.Mutes == nil {
+		dbStructure.Mutes = make(map[int]map[int]time.Time)
+	}
+	if dbStructure.HashtagIndex == nil {
+		dbStructure.HashtagIndex = make(map[string][]int)
+	}
+	if dbStructure.Media == nil {
+		dbStructure.Media = make(map[int]Media)
+	}
+	if dbStructure.Polls == nil {
+		dbStructure.Polls = make(map[int]StoredPoll)
+	}
+	if dbStructure.Drafts == nil {
+		dbStructure.Drafts = make(map[int]Draft)
+	}
+	if dbStructure.Conversations == nil {
+		dbStructure.Conversations = make(map[int]Conversation)
+	}
+	if dbStructure.Messages == nil {
+		dbStructure.Messages = make(map[int][]Message)
+	}
+	if dbStructure.Notifications == nil {
+		dbStructure.Notifications = make(map[int][]Notification)
+	}
+	if dbStructure.NotificationPrefs == nil {
+		dbStructure.NotificationPrefs = make(map[int]map[string]bool)
+	}
+	if dbStructure.Lists == nil {
+		dbStructure.Lists = make(map[int]List)
+	}
+}
+
 func (db *DB) EnsureDB() error {
 	f, err := os.Open(db.path)
 	defer f.Close()
@@ -117,42 +276,122 @@ func (db *DB) LoadDB() (*DBStructure, error) {
 	return &dbStructure, nil
 }
 
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
-	dbStructure, err := db.LoadDB()
+// ChirpOptions holds the optional relationships of a new chirp.
+type ChirpOptions struct {
+	InReplyTo int
+	QuoteOf int
+	MediaIDs []int
+	Poll *PollOptions
+	Visibility string
+}
+
+func (db *DB) CreateChirp(body string, authorID int, opts ChirpOptions) (Chirp, error) {
+	var newChirp Chirp
+	err := db.update(func(dbStructure *DBStructure) error {
+		chirp, err := createChirp(dbStructure, body, authorID, opts)
+		if err != nil {
+			return err
+		}
+		newChirp = hydrateChirp(dbStructure, chirp)
+		return nil
+	})
 	if err != nil {
-		fmt.Println("error loading db")
 		return Chirp{}, err
 	}
 
-	chirps := dbStructure.Chirps
+	return newChirp, nil
+}
 
-	var maxNum int
-	for n := range chirps {
-		maxNum = n
-		break
+// createChirp adds a chirp to dbStructure. On error dbStructure may be
+// partly modified and must not be written.
+func createChirp(dbStructure *DBStructure, body string, authorID int, opts ChirpOptions) (Chirp, error) {
+	visibility, err := normalizeVisibility(opts.Visibility)
+	if err != nil {
+		return Chirp{}, err
 	}
 
-	for n := range chirps {
-		if n > maxNum {
-			maxNum = n
+	// replies and quotes always point at an original, never at a rechirp
+	if opts.InReplyTo != 0 {
+		parent, ok := dbStructure.Chirps[resolveRechirp(dbStructure, opts.InReplyTo)]
+		if !ok || parent.Deleted || !canView(dbStructure, parent, authorID) {
+			return Chirp{}, ErrReplyTarget
+		}
+		if isBlocked(dbStructure, authorID, parent.AuthorID) {
+			return Chirp{}, ErrBlocked
 		}
+		opts.InReplyTo = parent.ID
+		parent.ReplyCount++
+		dbStructure.Chirps[parent.ID] = parent
 	}
 
-	id := maxNum + 1
+	if opts.QuoteOf != 0 {
+		original, ok := dbStructure.Chirps[resolveRechirp(dbStructure, opts.QuoteOf)]
+		if !ok || original.Deleted || !canView(dbStructure, original, authorID) {
+			return Chirp{}, ErrQuoteTarget
+		}
+		if isBlocked(dbStructure, authorID, original.AuthorID) {
+			return Chirp{}, ErrBlocked
+		}
+		opts.QuoteOf = original.ID
+		original.QuoteCount++
+		dbStructure.Chirps[original.ID] = original
+	}
+
+	id := nextChirpID(dbStructure)
+
+	if err := attachMedia(dbStructure, id, authorID, opts.MediaIDs); err != nil {
+		return Chirp{}, err
+	}
+
+	if opts.Poll != nil {
+		poll, err := newPoll(*opts.Poll)
+		if err != nil {
+			return Chirp{}, err
+		}
+		dbStructure.Polls[id] = poll
+	}
+
+	now := time.Now().UTC()
 
 	newChirp := Chirp{
 		ID: id,
 		Body: body,
 		AuthorID: authorID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		InReplyTo: opts.InReplyTo,
+		QuoteOf: opts.QuoteOf,
+		Entities: extractEntities(dbStructure, authorID, body),
+		MediaIDs: opts.MediaIDs,
+		Visibility: visibility,
 	}
 
-	dbStructure.Chirps[id] = newChirp
-	db.writeDB(*dbStructure)
+	insertChirp(dbStructure, newChirp)
+	notifyNewChirp(dbStructure, newChirp)
+	dbStructure.emit(ChirpCreated, newChirp, authorID)
 
 	return newChirp, nil
 }
 
-func (db *DB) GetChirps(authorID int, sortOrder string) ([]Chirp, error) {
+// nextChirpID hands out chirp IDs that are never reused, so references to
+// a deleted chirp can't end up pointing at a newer one.
+func nextChirpID(dbStructure *DBStructure) int {
+	maxNum := dbStructure.LastChirpID
+	for n := range dbStructure.Chirps {
+		if n > maxNum {
+			maxNum = n
+		}
+	}
+
+	dbStructure.LastChirpID = maxNum + 1
+	return dbStructure.LastChirpID
+}
+
+// GetChirps lists chirps as seen by viewerID, leaving out authors the viewer
+// has blocked, been blocked by, or muted, and chirps the viewer may not
+// read. Unlisted chirps are only included when listing a single author. A
+// viewerID of 0 is an anonymous viewer.
+func (db *DB) GetChirps(authorID int, sortOrder string, viewerID int) ([]Chirp, error) {
 
 	dbStructure, err := db.LoadDB()
 	if err != nil {
@@ -161,11 +400,15 @@ func (db *DB) GetChirps(authorID int, sortOrder string) ([]Chirp, error) {
 	}
 
 	chirps := dbStructure.Chirps
+	hidden := hiddenAuthors(dbStructure, viewerID)
 
 	v := make([]Chirp, 0, len(chirps))
 
 	for _, value := range chirps {
-		if authorID == 0 {
+		if value.Deleted || chirpHidden(dbStructure, value, hidden) || !canView(dbStructure, value, viewerID) {
+			continue
+		}
+		if authorID == 0 && listed(value) {
 			v = append(v, value)
 		} else if value.AuthorID == authorID {
 			v = append(v, value)
@@ -183,29 +426,72 @@ func (db *DB) GetChirps(authorID int, sortOrder string) ([]Chirp, error) {
 	} else {
 		return nil, errors.New("Invalid sort param")
 	}
+
+	for i := range v {
+		v[i] = viewChirp(dbStructure, v[i], viewerID)
+	}
 	
 	return v, nil
 }
 
+// errNoChanges lets an update function skip the write when it changed
+// nothing.
+var errNoChanges = errors.New("no changes")
+
+// update loads the database, applies fn and writes the result. Updates made
+// this way are serialised, so fn can safely read-modify-write counters; an
+// error from fn discards its changes.
+func (db *DB) update(fn func(*DBStructure) error) error {
+	db.updateMutex.Lock()
+	defer db.updateMutex.Unlock()
+
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return err
+	}
+
+	err = fn(dbStructure)
+	if errors.Is(err, errNoChanges) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = db.writeDB(*dbStructure)
+	if err != nil {
+		fmt.Printf("Error writing to db: %s", err)
+		return err
+	}
+	return nil
+}
+
 func (db *DB) writeDB(dbStructure DBStructure) error {
 	db.mutex.Lock()
-	defer db.mutex.Unlock()
 
 	f, err := json.Marshal(dbStructure)
 	if err != nil {
+		db.mutex.Unlock()
 		fmt.Println("error marshalling json: ", err)
 		return err
 	}
 
 	err = os.WriteFile(db.path, f, 0644)
+	db.mutex.Unlock()
 	if err != nil {
 		fmt.Println("error writing to db: ", err)
 		return err
 	}
+
+	db.dispatch(dbStructure.pending)
+	db.dispatchNotifications(dbStructure.pendingNotifications)
 	return nil
 }
 
-func (db *DB) GetChirpByID(idString string) (Chirp, error) {
+// GetChirpByID fetches a single chirp. Chirps between users who have blocked
+// each other, and chirps the viewer may not read, are reported as not found.
+func (db *DB) GetChirpByID(idString string, viewerID int) (Chirp, error) {
 	dbStructure, err := db.LoadDB()
 	if err != nil {
 		fmt.Println("Error loading db structure")
@@ -218,123 +504,202 @@ func (db *DB) GetChirpByID(idString string) (Chirp, error) {
 		return Chirp{}, err
 	}
 
-	if id > len(dbStructure.Chirps) {
+	chirp, ok := dbStructure.Chirps[id]
+	if !ok || chirp.Deleted {
+		return Chirp{}, ErrChirpID
+	}
+
+	if isBlocked(dbStructure, viewerID, chirp.AuthorID) || !canView(dbStructure, chirp, viewerID) {
 		return Chirp{}, ErrChirpID
 	}
 
-	return dbStructure.Chirps[id], nil
+	return viewChirp(dbStructure, chirp, viewerID), nil
 }
 
-func (db *DB) CreateUser(email string, hashed string) (User, error) {
+// GetChirpsByIDs fetches chirps in the order given, skipping any that no
+// longer exist or that viewerID should not see.
+func (db *DB) GetChirpsByIDs(ids []int, viewerID int) ([]Chirp, error) {
 	dbStructure, err := db.LoadDB()
 	if err != nil {
 		fmt.Println("Error loading db structure")
-		return User{}, err
+		return nil, err
 	}
 
-	users := dbStructure.Users
-
-	var maxNum int
-	for n := range users {
-		maxNum = n
-		break
-	}
+	hidden := hiddenAuthors(dbStructure, viewerID)
 
-	for n := range users {
-		if n > maxNum {
-			maxNum = n
+	chirps := make([]Chirp, 0, len(ids))
+	for _, id := range ids {
+		chirp, ok := dbStructure.Chirps[id]
+		if !ok || chirp.Deleted || chirpHidden(dbStructure, chirp, hidden) || !canView(dbStructure, chirp, viewerID) {
+			continue
 		}
+		chirps = append(chirps, viewChirp(dbStructure, chirp, viewerID))
 	}
 
-	id := maxNum + 1
+	return chirps, nil
+}
 
+// CreateUser adds a user. An empty handle is replaced with one derived from
+// the email address.
+func (db *DB) CreateUser(email string, hashed string, role string, handle string) (User, error) {
 	newUser := User{
-		ID: id,
 		Email: email,
 		Password: hashed,
 		IsChirpyRed: false,
+		Role: role,
 	}
 
-	dbStructure.Users[id] = newUser
-	db.writeDB(*dbStructure)
+	err := db.update(func(dbStructure *DBStructure) error {
+		if emailTaken(dbStructure, 0, email) {
+			return ErrEmailTaken
+		}
+
+		if handle == "" {
+			newUser.Handle = suggestHandle(dbStructure, email)
+		} else if err := claimHandle(dbStructure, 0, handle); err != nil {
+			return err
+		} else {
+			newUser.Handle = handle
+		}
+
+		newUser.ID = nextUserID(dbStructure)
+		dbStructure.Users[newUser.ID] = newUser
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
 
 	return newUser, nil
 }
 
-func (db *DB) GetUserByEmail(email string) (User, error) {
+// nextUserID hands out user IDs that are never reused, so a purged account's
+// ID can't be inherited by someone else.
+func nextUserID(dbStructure *DBStructure) int {
+	maxNum := dbStructure.LastUserID
+	for n := range dbStructure.Users {
+		if n > maxNum {
+			maxNum = n
+		}
+	}
+
+	dbStructure.LastUserID = maxNum + 1
+	return dbStructure.LastUserID
+}
+
+// emailTaken reports whether anyone but userID has registered email.
+// Emails are compared without regard to case, as at login.
+func emailTaken(dbStructure *DBStructure, userID int, email string) bool {
+	for id, user := range dbStructure.Users {
+		if id != userID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) GetUserByID(ID int) (User, error) {
 	dbStructure, err := db.LoadDB()
 	if err != nil {
 		fmt.Println("Error loading db structure")
 		return User{}, err
 	}
 
-	users := dbStructure.Users
-	user := User{}
-
-	for n := range users {
-		if users[n].Email == email {
-			user = users[n]
-		} else if n == len(users) {
-			return User{}, ErrUserNotFound
-		}
+	user, ok := dbStructure.Users[ID]
+	if !ok {
+		return User{}, ErrUserNotFound
 	}
 
 	return user, nil
 }
 
-func (db *DB) UpdateUser(ID int, updatedUser User) (User, error) {
+func (db *DB) GetUserByEmail(email string) (User, error) {
 	dbStructure, err := db.LoadDB()
 	if err != nil {
 		fmt.Println("Error loading db structure")
 		return User{}, err
 	}
 
-	users := dbStructure.Users
-	user := User{}
-
-	for n := range users {
-		if users[n].ID == ID {
-			user = users[n]
-			if updatedUser.Email != "" {
-				user.Email = updatedUser.Email
-			}
-			if updatedUser.Password != "" {
-				user.Password = updatedUser.Password
-			}
-			users[n] = user
-			db.writeDB(*dbStructure)
+	for _, user := range dbStructure.Users {
+		if strings.EqualFold(user.Email, email) {
 			return user, nil
-		} else if n == len(users) {
-			return User{}, ErrUserNotFound
 		}
 	}
-	return user, nil
+
+	return User{}, ErrUserNotFound
 }
 
-func (db *DB) UpdateChirpyRedStatus (ID int, status bool) error {
-	dbStructure, err := db.LoadDB()
+func (db *DB) UpdateUser(ID int, updatedUser User) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[ID]
+		if !ok {
+			return ErrUserNotFound
+		}
+
+		if err := applyUserUpdate(dbStructure, &user, updatedUser); err != nil {
+			return err
+		}
+		dbStructure.Users[ID] = user
+		return nil
+	})
 	if err != nil {
-		fmt.Println("Error loading db structure")
-		return err
+		return User{}, err
 	}
 
-	users := dbStructure.Users
-	user := User{}
+	return user, nil
+}
 
-	for n := range users {
-		if users[n].ID == ID {
-			user = users[n]
-			if user.IsChirpyRed != status {
-				user.IsChirpyRed = status
-				users[n] = user
-				db.writeDB(*dbStructure)
-				return nil
-			}
+// applyUserUpdate sets the email and password of user from the non-empty
+// fields of updatedUser.
+func applyUserUpdate(dbStructure *DBStructure, user *User, updatedUser User) error {
+	if updatedUser.Email != "" {
+		if emailTaken(dbStructure, user.ID, updatedUser.Email) {
+			return ErrEmailTaken
+		}
+		user.Email = updatedUser.Email
+	}
+	if updatedUser.Password != "" {
+		user.Password = updatedUser.Password
+	}
+	return nil
+}
+
+func (db *DB) UpdateChirpyRedStatus (ID int, status bool) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[ID]
+		if !ok {
+			return ErrUserNotFound
+		}
+		if user.IsChirpyRed == status {
 			return errors.New("User status not changed")
 		}
+
+		user.IsChirpyRed = status
+		dbStructure.Users[ID] = user
+		return nil
+	})
+}
+
+func (db *DB) UpdateUserRole(ID int, role string) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[ID]
+		if !ok {
+			return ErrUserNotFound
+		}
+
+		user.Role = role
+		dbStructure.Users[ID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
 	}
-	return ErrUserNotFound
 
+	return user, nil
 }
 
 func (db *DB) GenerateRefreshToken(ID int) (string, error) {
@@ -355,23 +720,19 @@ func (db *DB) GenerateRefreshToken(ID int) (string, error) {
 	current := time.Now()
 	expiration := current.Add(time.Second * time.Duration(60*24*3600))
 
-	dbStructure, err := db.LoadDB()
-	if err != nil {
-		fmt.Println("error loading db")
-		return "" , err
-	}
-
-	refreshTokens := dbStructure.RefreshTokens
-
 	newRefreshToken := RefreshToken{
 		Token: refreshTokenString,
 		UserID: ID,
 		ExpiresAt: expiration,
 	}
 
-	refreshTokens[refreshTokenString] = newRefreshToken
-
-	db.writeDB(*dbStructure)
+	err = db.update(func(dbStructure *DBStructure) error {
+		dbStructure.RefreshTokens[refreshTokenString] = newRefreshToken
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
 
 	return refreshTokenString, nil
 }
@@ -394,11 +755,19 @@ func (db *DB) GenerateAccessToken(refreshtoken string) (string, error) {
 		return "", errors.New("refresh token expired")
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer: "chirpy",
-		IssuedAt: jwt.NewNumericDate(current),
-		ExpiresAt: jwt.NewNumericDate(current.Add(time.Second * time.Duration(3600))),
-		Subject: strconv.Itoa(val.UserID),
+	role := dbStructure.Users[val.UserID].Role
+	if role == "" {
+		role = RoleUser
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "chirpy",
+			IssuedAt: jwt.NewNumericDate(current),
+			ExpiresAt: jwt.NewNumericDate(current.Add(time.Second * time.Duration(3600))),
+			Subject: strconv.Itoa(val.UserID),
+		},
 	})
 
 	newJWT, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
@@ -410,47 +779,40 @@ func (db *DB) GenerateAccessToken(refreshtoken string) (string, error) {
 	return newJWT, nil
 }
 
-func (db *DB) RevokeRefreshToken(refreshtoken string) error {
+func (db *DB) GetRefreshToken(refreshtoken string) (RefreshToken, error) {
 	dbStructure, err := db.LoadDB()
 	if err != nil {
 		fmt.Printf("Error loading db: %s", err)
-		return err
+		return RefreshToken{}, err
 	}
 
-	delete(dbStructure.RefreshTokens, refreshtoken)
-
-	err = db.writeDB(*dbStructure)
-	if err != nil {
-		fmt.Printf("Error writing to db: %s", err)
-		return err
+	val, ok := dbStructure.RefreshTokens[refreshtoken]
+	if !ok {
+		return RefreshToken{}, errors.New("refresh token not found")
 	}
 
-	return nil
+	return val, nil
 }
 
-func (db *DB) DeleteChirpFromDB(userID int, chirpID int) error {
-	dbStructure, err := db.LoadDB()
-	if err != nil {
-		fmt.Println("Error loading db structure")
-		return err
-	}
-
-	val, ok := dbStructure.Chirps[chirpID]
-	if !ok {
-		return ErrChirpID
-	}
-
-	if val.AuthorID != userID {
-		return ErrAuthorization
-	}
+func (db *DB) RevokeRefreshToken(refreshtoken string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		delete(dbStructure.RefreshTokens, refreshtoken)
+		return nil
+	})
+}
 
-	delete(dbStructure.Chirps, chirpID)
+func (db *DB) DeleteChirpFromDB(userID int, chirpID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		val, ok := dbStructure.Chirps[chirpID]
+		if !ok || val.Deleted {
+			return ErrChirpID
+		}
 
-	err = db.writeDB(*dbStructure)
-	if err != nil {
-		fmt.Printf("Error writing to db: %s", err)
-		return err
-	}
+		if val.AuthorID != userID && !dbStructure.Users[userID].CanModerate() {
+			return ErrAuthorization
+		}
 
-	return nil
+		removeChirp(dbStructure, chirpID)
+		return nil
+	})
 }
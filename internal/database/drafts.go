@@ -0,0 +1,243 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MaxDraftsPerUser bounds how many drafts, scheduled or not, a user keeps.
+const MaxDraftsPerUser = 100
+
+var ErrDraftNotFound = errors.New("Draft not found")
+var ErrDraftChanged = errors.New("Draft was changed while being published")
+var ErrTooManyDrafts = fmt.Errorf("Users can keep at most %d drafts", MaxDraftsPerUser)
+
+// DraftPoll is a poll waiting to be published. Its clock starts when the
+// draft is published.
+type DraftPoll struct {
+	Options []string `json:"options"`
+	DurationMinutes int `json:"duration_minutes"`
+}
+
+// Draft is an unpublished chirp, visible only to its author. A draft with
+// PublishAt set is published by the scheduler once that time arrives. Body
+// is kept as written; it is only cleaned when published.
+type Draft struct {
+	ID int `json:"id"`
+	AuthorID int `json:"author_id"`
+	Body string `json:"body"`
+	InReplyTo int `json:"in_reply_to,omitempty"`
+	QuoteOf int `json:"quote_of,omitempty"`
+	MediaIDs []int `json:"media_ids,omitempty"`
+	Poll *DraftPoll `json:"poll,omitempty"`
+	Visibility string `json:"visibility"`
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	PublishError string `json:"publish_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SaveDraft creates draft when its ID is 0 and otherwise replaces the
+// author's existing draft with that ID. Saving clears any earlier publish
+// error.
+func (db *DB) SaveDraft(draft Draft) (Draft, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[draft.AuthorID]; !ok {
+			return ErrUserNotFound
+		}
+
+		visibility, err := normalizeVisibility(draft.Visibility)
+		if err != nil {
+			return err
+		}
+		draft.Visibility = visibility
+
+		if len(draft.MediaIDs) > MaxChirpMedia {
+			return ErrTooManyMedia
+		}
+		for _, id := range draft.MediaIDs {
+			m, ok := dbStructure.Media[id]
+			if !ok || m.OwnerID != draft.AuthorID || m.ChirpID != 0 {
+				return ErrMediaUnavailable
+			}
+		}
+
+		now := time.Now().UTC()
+		draft.UpdatedAt = now
+		draft.PublishError = ""
+		if draft.PublishAt != nil {
+			publishAt := draft.PublishAt.UTC()
+			draft.PublishAt = &publishAt
+		}
+
+		if draft.ID == 0 {
+			if len(userDrafts(dbStructure, draft.AuthorID)) >= MaxDraftsPerUser {
+				return ErrTooManyDrafts
+			}
+			dbStructure.LastDraftID++
+			draft.ID = dbStructure.LastDraftID
+			draft.CreatedAt = now
+		} else {
+			existing, ok := dbStructure.Drafts[draft.ID]
+			if !ok || existing.AuthorID != draft.AuthorID {
+				return ErrDraftNotFound
+			}
+			draft.CreatedAt = existing.CreatedAt
+		}
+
+		dbStructure.Drafts[draft.ID] = draft
+		return nil
+	})
+	if err != nil {
+		return Draft{}, err
+	}
+
+	return draft, nil
+}
+
+// GetDrafts lists a user's drafts: scheduled ones first in the order they
+// will go out, then the rest, most recently edited first.
+func (db *DB) GetDrafts(userID int) ([]Draft, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	drafts := userDrafts(dbStructure, userID)
+	sort.Slice(drafts, func(i, j int) bool {
+		a, b := drafts[i], drafts[j]
+		if (a.PublishAt == nil) != (b.PublishAt == nil) {
+			return a.PublishAt != nil
+		}
+		if a.PublishAt != nil && !a.PublishAt.Equal(*b.PublishAt) {
+			return a.PublishAt.Before(*b.PublishAt)
+		}
+		return a.UpdatedAt.After(b.UpdatedAt)
+	})
+
+	return drafts, nil
+}
+
+func userDrafts(dbStructure *DBStructure, userID int) []Draft {
+	drafts := []Draft{}
+	for _, draft := range dbStructure.Drafts {
+		if draft.AuthorID == userID {
+			drafts = append(drafts, draft)
+		}
+	}
+	return drafts
+}
+
+func (db *DB) GetDraft(userID int, draftID int) (Draft, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return Draft{}, err
+	}
+
+	draft, ok := dbStructure.Drafts[draftID]
+	if !ok || draft.AuthorID != userID {
+		return Draft{}, ErrDraftNotFound
+	}
+	return draft, nil
+}
+
+func (db *DB) DeleteDraft(userID int, draftID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		draft, ok := dbStructure.Drafts[draftID]
+		if !ok || draft.AuthorID != userID {
+			return ErrDraftNotFound
+		}
+		delete(dbStructure.Drafts, draftID)
+		return nil
+	})
+}
+
+// GetDueDrafts returns every draft scheduled at or before now, earliest
+// first.
+func (db *DB) GetDueDrafts(now time.Time) ([]Draft, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	due := []Draft{}
+	for _, draft := range dbStructure.Drafts {
+		if draft.PublishAt != nil && !draft.PublishAt.After(now) {
+			due = append(due, draft)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].PublishAt.Before(*due[j].PublishAt)
+	})
+
+	return due, nil
+}
+
+// PublishDraft turns draft into a chirp with the given cleaned body and
+// options, and removes the draft in the same write so it can never be
+// published twice. It fails with ErrDraftChanged if the draft was edited
+// after it was read.
+func (db *DB) PublishDraft(draft Draft, body string, opts ChirpOptions) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Drafts[draft.ID]
+		if !ok || stored.AuthorID != draft.AuthorID {
+			return ErrDraftNotFound
+		}
+		if !stored.UpdatedAt.Equal(draft.UpdatedAt) {
+			return ErrDraftChanged
+		}
+
+		var err error
+		chirp, err = createChirp(dbStructure, body, draft.AuthorID, opts)
+		if err != nil {
+			return err
+		}
+		chirp = hydrateChirp(dbStructure, chirp)
+
+		delete(dbStructure.Drafts, draft.ID)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// FailDraft unschedules a draft that could not be published and records
+// why, so its author can fix it and try again. Like PublishDraft, it fails
+// with ErrDraftChanged if the draft was edited after it was read, since the
+// reason may not apply to the newer version.
+func (db *DB) FailDraft(draft Draft, reason string) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		stored, ok := dbStructure.Drafts[draft.ID]
+		if !ok || stored.AuthorID != draft.AuthorID {
+			return ErrDraftNotFound
+		}
+		if !stored.UpdatedAt.Equal(draft.UpdatedAt) {
+			return ErrDraftChanged
+		}
+
+		stored.PublishAt = nil
+		stored.PublishError = reason
+		dbStructure.Drafts[draft.ID] = stored
+		return nil
+	})
+}
+
+// draftMedia returns the media IDs some draft is holding on to.
+func draftMedia(dbStructure *DBStructure) map[int]bool {
+	held := make(map[int]bool)
+	for _, draft := range dbStructure.Drafts {
+		for _, id := range draft.MediaIDs {
+			held[id] = true
+		}
+	}
+	return held
+}
@@ -0,0 +1,91 @@
+package database
+
+import "time"
+
+const (
+	ChirpCreated = "chirp.created"
+	ChirpUpdated = "chirp.updated"
+	ChirpDeleted = "chirp.deleted"
+	ChirpLiked = "chirp.liked"
+	ChirpUnliked = "chirp.unliked"
+	PollClosed = "poll.closed"
+)
+
+// ChirpEvent describes a change to a stored chirp. Chirp is hydrated the same
+// way reads are; for deletions it holds the chirp as it was before removal.
+// UserID is the user who made the change, or 0 when it was a side effect
+// such as a cascading delete or an account purge.
+type ChirpEvent struct {
+	Type string
+	Chirp Chirp
+	UserID int
+	At time.Time
+}
+
+// OnChirpEvent registers fn to be called after every committed change to a
+// chirp. Listeners run synchronously once the write has reached disk, so they
+// must be quick and must not write to the DB themselves.
+func (db *DB) OnChirpEvent(fn func(ChirpEvent)) {
+	db.listenerMutex.Lock()
+	defer db.listenerMutex.Unlock()
+
+	db.listeners = append(db.listeners, fn)
+}
+
+// emit queues an event to be delivered when dbStructure is written.
+func (dbStructure *DBStructure) emit(eventType string, chirp Chirp, userID int) {
+	dbStructure.pending = append(dbStructure.pending, ChirpEvent{
+		Type: eventType,
+		Chirp: hydrateChirp(dbStructure, chirp),
+		UserID: userID,
+		At: time.Now().UTC(),
+	})
+}
+
+// NotificationEvent is a notification just added to UserID's inbox, shaped
+// as a group of one like the entries GetNotifications returns.
+type NotificationEvent struct {
+	UserID int
+	Notification NotificationGroup
+}
+
+// OnNotification registers fn to be called for every notification added,
+// under the same rules as OnChirpEvent.
+func (db *DB) OnNotification(fn func(NotificationEvent)) {
+	db.listenerMutex.Lock()
+	defer db.listenerMutex.Unlock()
+
+	db.notificationListeners = append(db.notificationListeners, fn)
+}
+
+func (db *DB) dispatchNotifications(events []NotificationEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	db.listenerMutex.RLock()
+	listeners := db.notificationListeners
+	db.listenerMutex.RUnlock()
+
+	for _, event := range events {
+		for _, fn := range listeners {
+			fn(event)
+		}
+	}
+}
+
+func (db *DB) dispatch(events []ChirpEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	db.listenerMutex.RLock()
+	listeners := db.listeners
+	db.listenerMutex.RUnlock()
+
+	for _, event := range events {
+		for _, fn := range listeners {
+			fn(event)
+		}
+	}
+}
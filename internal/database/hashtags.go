@@ -0,0 +1,42 @@
+package database
+
+import (
+	"fmt"
+
+	"internal/entities"
+)
+
+// extractEntities parses a stored chirp body and resolves its mentions to
+// users by handle. Mentions of a user who has blocked, or been blocked by,
+// the author are left unresolved.
+func extractEntities(dbStructure *DBStructure, authorID int, body string) entities.Entities {
+	found := entities.Extract(body)
+
+	for i, mention := range found.Mentions {
+		user, ok := findUserByHandle(dbStructure, mention.Username)
+		if !ok || isBlocked(dbStructure, authorID, user.ID) {
+			continue
+		}
+		found.Mentions[i].UserID = user.ID
+	}
+
+	return found
+}
+
+// GetHashtagChirps returns up to limit chirps tagged with tag, newest first,
+// starting below the chirp ID before. Unlisted chirps are left out.
+func (db *DB) GetHashtagChirps(tag string, viewerID int, before int, limit int) ([]Chirp, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	ids := dbStructure.HashtagIndex[entities.NormalizeHashtag(tag)]
+
+	hidden := hiddenAuthors(dbStructure, viewerID)
+
+	return mergeIndexes(dbStructure, [][]int{ids}, before, limit, viewerID, func(chirp Chirp) bool {
+		return listed(chirp) && !chirpHidden(dbStructure, chirp, hidden)
+	}), nil
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// LikeChirp records that userID likes chirpID. Liking a chirp twice is not
+// an error and leaves the original like in place.
+func (db *DB) LikeChirp(userID int, chirpID int) (Chirp, error) {
+	return db.setLike(userID, chirpID, true)
+}
+
+// UnlikeChirp removes a like. Removing a like that does not exist is not an
+// error.
+func (db *DB) UnlikeChirp(userID int, chirpID int) (Chirp, error) {
+	return db.setLike(userID, chirpID, false)
+}
+
+func (db *DB) setLike(userID int, chirpID int, liked bool) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbStructure *DBStructure) error {
+		// liking a rechirp likes the chirp it shares
+		chirpID = resolveRechirp(dbStructure, chirpID)
+
+		var ok bool
+		chirp, ok = dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted || !canView(dbStructure, chirp, userID) {
+			return ErrChirpID
+		}
+
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return ErrUserNotFound
+		}
+
+		if liked && isBlocked(dbStructure, userID, chirp.AuthorID) {
+			return ErrBlocked
+		}
+
+		likers := dbStructure.Likes[chirpID]
+		_, already := likers[userID]
+		if liked == already {
+			chirp = viewChirp(dbStructure, chirp, userID)
+			return errNoChanges
+		}
+
+		if liked {
+			if likers == nil {
+				likers = make(map[int]time.Time)
+				dbStructure.Likes[chirpID] = likers
+			}
+			likers[userID] = time.Now().UTC()
+		} else {
+			delete(likers, userID)
+			if len(likers) == 0 {
+				delete(dbStructure.Likes, chirpID)
+			}
+		}
+
+		chirp.LikeCount = len(likers)
+		dbStructure.Chirps[chirpID] = chirp
+
+		if liked {
+			notify(dbStructure, chirp.AuthorID, NotificationLike, userID, chirpID)
+			dbStructure.emit(ChirpLiked, chirp, userID)
+		} else {
+			retractNotification(dbStructure, chirp.AuthorID, NotificationLike, userID, chirpID)
+			dbStructure.emit(ChirpUnliked, chirp, userID)
+		}
+
+		chirp = viewChirp(dbStructure, chirp, userID)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	chirp.Liked = &liked
+	return chirp, nil
+}
+
+// Like is a single user's like of a chirp.
+type Like struct {
+	ChirpID int
+	UserID int
+	LikedAt time.Time
+}
+
+// GetLikesSince returns every like made at or after since, oldest first.
+func (db *DB) GetLikesSince(since time.Time) ([]Like, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	likes := []Like{}
+	for chirpID, likers := range dbStructure.Likes {
+		for userID, at := range likers {
+			if !at.Before(since) {
+				likes = append(likes, Like{ChirpID: chirpID, UserID: userID, LikedAt: at})
+			}
+		}
+	}
+
+	sort.Slice(likes, func(i, j int) bool {
+		return likes[i].LikedAt.Before(likes[j].LikedAt)
+	})
+
+	return likes, nil
+}
+
+// GetLikedChirps returns the chirps userID has liked that viewerID may read,
+// most recently liked first. As in other listings, chirps by authors the
+// viewer has blocked, been blocked by or muted are left out, as are shares
+// of them.
+func (db *DB) GetLikedChirps(userID int, viewerID int) ([]Chirp, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	if _, ok := dbStructure.Users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+
+	hidden := hiddenAuthors(dbStructure, viewerID)
+
+	likedAt := make(map[int]time.Time)
+	chirps := []Chirp{}
+	for chirpID, likers := range dbStructure.Likes {
+		at, ok := likers[userID]
+		if !ok {
+			continue
+		}
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted || chirpHidden(dbStructure, chirp, hidden) || !canView(dbStructure, chirp, viewerID) {
+			continue
+		}
+		likedAt[chirpID] = at
+		chirps = append(chirps, viewChirp(dbStructure, chirp, viewerID))
+	}
+
+	sort.Slice(chirps, func(i, j int) bool {
+		return likedAt[chirps[i].ID].After(likedAt[chirps[j].ID])
+	})
+
+	return chirps, nil
+}
+
+// SetViewerState fills in the viewer-specific fields of chirps for viewerID.
+// A viewerID of 0 means an anonymous viewer and leaves them unset.
+func (db *DB) SetViewerState(viewerID int, chirps []Chirp) error {
+	if viewerID == 0 || len(chirps) == 0 {
+		return nil
+	}
+
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return err
+	}
+
+	now := time.Now()
+	for i := range chirps {
+		_, liked := dbStructure.Likes[chirps[i].ID][viewerID]
+		chirps[i].Liked = &liked
+		if chirps[i].Poll != nil {
+			chirps[i].Poll = pollView(dbStructure, chirps[i].ID, viewerID, now)
+		}
+		if original := chirps[i].Original; original != nil && original.Poll != nil {
+			original.Poll = pollView(dbStructure, original.ID, viewerID, now)
+		}
+	}
+
+	return nil
+}
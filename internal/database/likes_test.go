@@ -0,0 +1,68 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentLikes(t *testing.T) {
+	db, err := NewDB(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	author, err := db.CreateUser("author@example.com", "hash", RoleUser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := db.CreateChirp("hello", author.ID, ChirpOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const likers = 20
+	ids := make([]int, likers)
+	for i := range ids {
+		user, err := db.CreateUser(fmt.Sprintf("liker%d@example.com", i), "hash", RoleUser, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = user.ID
+	}
+
+	// every user likes at once, and half of them unlike again straight away
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id int) {
+			defer wg.Done()
+			if _, err := db.LikeChirp(id, chirp.ID); err != nil {
+				t.Error(err)
+				return
+			}
+			if i%2 == 1 {
+				if _, err := db.UnlikeChirp(id, chirp.ID); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i, id)
+	}
+	wg.Wait()
+
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := likers / 2
+	if got := len(dbStructure.Likes[chirp.ID]); got != want {
+		t.Errorf("stored likes = %d, want %d", got, want)
+	}
+	if got := dbStructure.Chirps[chirp.ID].LikeCount; got != want {
+		t.Errorf("like_count = %d, want %d", got, want)
+	}
+	if got := len(dbStructure.Notifications[author.ID]); got != want {
+		t.Errorf("like notifications = %d, want %d", got, want)
+	}
+}
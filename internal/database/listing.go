@@ -0,0 +1,121 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChirpFilter selects and pages the chirps returned by ListChirps. After and
+// Before are chirp IDs in the listing's own order: After continues past a
+// page, Before steps back to the one ahead of it. Zero values mean no
+// restriction.
+type ChirpFilter struct {
+	ViewerID int
+	AuthorIDs []int
+	Since time.Time
+	Until time.Time
+	HasReplies *bool
+	Contains string
+	Descending bool
+	After int
+	Before int
+	Limit int
+}
+
+// ChirpList is one page of a listing and whether it has neighbours.
+type ChirpList struct {
+	Chirps []Chirp
+	HasNext bool
+	HasPrev bool
+}
+
+func (db *DB) ListChirps(filter ChirpFilter) (ChirpList, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return ChirpList{}, err
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = len(dbStructure.Chirps)
+	}
+
+	hidden := hiddenAuthors(dbStructure, filter.ViewerID)
+	authors := make(map[int]bool)
+	for _, id := range filter.AuthorIDs {
+		authors[id] = true
+	}
+	contains := strings.ToLower(filter.Contains)
+
+	matched := []Chirp{}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.Deleted || chirpHidden(dbStructure, chirp, hidden) || !canView(dbStructure, chirp, filter.ViewerID) {
+			continue
+		}
+		// unlisted chirps still show when browsing their author
+		if len(authors) == 0 && !listed(chirp) {
+			continue
+		}
+		if len(authors) > 0 && !authors[chirp.AuthorID] {
+			continue
+		}
+		if !filter.Since.IsZero() && chirp.CreatedAt.Before(filter.Since) {
+			continue
+		}
+		if !filter.Until.IsZero() && !chirp.CreatedAt.Before(filter.Until) {
+			continue
+		}
+		if filter.HasReplies != nil && (chirp.ReplyCount > 0) != *filter.HasReplies {
+			continue
+		}
+		if contains != "" && !strings.Contains(strings.ToLower(chirp.Body), contains) {
+			continue
+		}
+		matched = append(matched, chirp)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if filter.Descending {
+			return matched[i].ID > matched[j].ID
+		}
+		return matched[i].ID < matched[j].ID
+	})
+
+	// position of the first chirp that sorts after id
+	after := func(id int) int {
+		return sort.Search(len(matched), func(i int) bool {
+			if filter.Descending {
+				return matched[i].ID < id
+			}
+			return matched[i].ID > id
+		})
+	}
+
+	start, end := 0, len(matched)
+	if filter.After > 0 {
+		start = after(filter.After)
+		end = min(start+filter.Limit, len(matched))
+	} else if filter.Before > 0 {
+		// the cursor chirp itself may be gone, so find where it would sit
+		end = after(filter.Before)
+		if end > 0 && matched[end-1].ID == filter.Before {
+			end--
+		}
+		start = max(end-filter.Limit, 0)
+	} else {
+		end = min(filter.Limit, len(matched))
+	}
+
+	list := ChirpList{
+		Chirps: matched[start:end],
+		HasNext: end < len(matched),
+		HasPrev: start > 0,
+	}
+	for i := range list.Chirps {
+		list.Chirps[i] = viewChirp(dbStructure, list.Chirps[i], filter.ViewerID)
+	}
+
+	return list, nil
+}
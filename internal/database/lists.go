@@ -0,0 +1,428 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxListNameLength = 25
+	MaxListDescriptionLength = 100
+	MaxListsPerUser = 100
+	MaxListMembers = 500
+)
+
+var ErrListNotFound = errors.New("List not found")
+var ErrInvalidList = fmt.Errorf("List names are 1 to %d characters and descriptions at most %d", MaxListNameLength, MaxListDescriptionLength)
+var ErrTooManyLists = fmt.Errorf("Users can have at most %d lists", MaxListsPerUser)
+var ErrListFull = fmt.Errorf("Lists can have at most %d members", MaxListMembers)
+var ErrOwnList = errors.New("Users cannot subscribe to their own lists")
+
+// List is a named set of accounts whose chirps can be read as a timeline.
+// Private lists are only ever seen by their owner.
+type List struct {
+	ID int `json:"id"`
+	OwnerID int `json:"owner_id"`
+	Name string `json:"name"`
+	Description string `json:"description"`
+	Private bool `json:"private"`
+	Members map[int]time.Time `json:"members"`
+	Subscribers map[int]time.Time `json:"subscribers"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListSummary is a list as shown to a viewer. Who subscribes is left out;
+// only the count is public.
+type ListSummary struct {
+	ID int `json:"id"`
+	OwnerID int `json:"owner_id"`
+	Name string `json:"name"`
+	Description string `json:"description"`
+	Private bool `json:"private"`
+	MemberCount int `json:"member_count"`
+	SubscriberCount int `json:"subscriber_count"`
+	Subscribed bool `json:"subscribed"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListUpdate changes the fields that are set and leaves the rest alone.
+type ListUpdate struct {
+	Name *string
+	Description *string
+	Private *bool
+}
+
+func (l List) summary(viewerID int) ListSummary {
+	_, subscribed := l.Subscribers[viewerID]
+	return ListSummary{
+		ID: l.ID,
+		OwnerID: l.OwnerID,
+		Name: l.Name,
+		Description: l.Description,
+		Private: l.Private,
+		MemberCount: len(l.Members),
+		SubscriberCount: len(l.Subscribers),
+		Subscribed: subscribed,
+		CreatedAt: l.CreatedAt,
+		UpdatedAt: l.UpdatedAt,
+	}
+}
+
+// canSeeList reports whether viewerID may see a list at all: private lists
+// only to their owner, and no list across a block with its owner.
+func canSeeList(dbStructure *DBStructure, list List, viewerID int) bool {
+	if list.OwnerID == viewerID {
+		return true
+	}
+	return !list.Private && !isBlocked(dbStructure, list.OwnerID, viewerID)
+}
+
+// ownedList looks up a list that userID owns. Lists userID can't see are
+// reported as missing rather than forbidden.
+func ownedList(dbStructure *DBStructure, userID int, listID int) (List, error) {
+	list, ok := dbStructure.Lists[listID]
+	if !ok || !canSeeList(dbStructure, list, userID) {
+		return List{}, ErrListNotFound
+	}
+	if list.OwnerID != userID {
+		return List{}, ErrAuthorization
+	}
+	return list, nil
+}
+
+func validateListText(name string, description string) error {
+	if name == "" || utf8.RuneCountInString(name) > MaxListNameLength {
+		return ErrInvalidList
+	}
+	if utf8.RuneCountInString(description) > MaxListDescriptionLength {
+		return ErrInvalidList
+	}
+	return nil
+}
+
+func (db *DB) CreateList(ownerID int, name string, description string, private bool) (ListSummary, error) {
+	var list List
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[ownerID]; !ok {
+			return ErrUserNotFound
+		}
+
+		name, description = strings.TrimSpace(name), strings.TrimSpace(description)
+		if err := validateListText(name, description); err != nil {
+			return err
+		}
+
+		owned := 0
+		for _, l := range dbStructure.Lists {
+			if l.OwnerID == ownerID {
+				owned++
+			}
+		}
+		if owned >= MaxListsPerUser {
+			return ErrTooManyLists
+		}
+
+		now := time.Now().UTC()
+		dbStructure.LastListID++
+		list = List{
+			ID: dbStructure.LastListID,
+			OwnerID: ownerID,
+			Name: name,
+			Description: description,
+			Private: private,
+			Members: make(map[int]time.Time),
+			Subscribers: make(map[int]time.Time),
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		dbStructure.Lists[list.ID] = list
+		return nil
+	})
+	if err != nil {
+		return ListSummary{}, err
+	}
+
+	return list.summary(ownerID), nil
+}
+
+// UpdateList applies update to a list ownerID owns. Making a list private
+// drops its subscribers, since they can no longer read it.
+func (db *DB) UpdateList(ownerID int, listID int, update ListUpdate) (ListSummary, error) {
+	var list List
+	err := db.update(func(dbStructure *DBStructure) error {
+		var err error
+		list, err = ownedList(dbStructure, ownerID, listID)
+		if err != nil {
+			return err
+		}
+
+		name, description := list.Name, list.Description
+		if update.Name != nil {
+			name = strings.TrimSpace(*update.Name)
+		}
+		if update.Description != nil {
+			description = strings.TrimSpace(*update.Description)
+		}
+		if err := validateListText(name, description); err != nil {
+			return err
+		}
+		list.Name, list.Description = name, description
+
+		if update.Private != nil {
+			list.Private = *update.Private
+			if list.Private {
+				list.Subscribers = make(map[int]time.Time)
+			}
+		}
+
+		list.UpdatedAt = time.Now().UTC()
+		dbStructure.Lists[listID] = list
+		return nil
+	})
+	if err != nil {
+		return ListSummary{}, err
+	}
+
+	return list.summary(ownerID), nil
+}
+
+func (db *DB) DeleteList(ownerID int, listID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, err := ownedList(dbStructure, ownerID, listID); err != nil {
+			return err
+		}
+		delete(dbStructure.Lists, listID)
+		return nil
+	})
+}
+
+// GetList returns a list as seen by viewerID.
+func (db *DB) GetList(listID int, viewerID int) (ListSummary, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return ListSummary{}, err
+	}
+
+	list, ok := dbStructure.Lists[listID]
+	if !ok || !canSeeList(dbStructure, list, viewerID) {
+		return ListSummary{}, ErrListNotFound
+	}
+
+	return list.summary(viewerID), nil
+}
+
+// GetOwnedLists returns the lists ownerID has made, as seen by viewerID,
+// newest first. Private lists are only included for the owner.
+func (db *DB) GetOwnedLists(ownerID int, viewerID int) ([]ListSummary, error) {
+	return db.getLists(viewerID, func(list List) bool { return list.OwnerID == ownerID })
+}
+
+// GetSubscribedLists returns the lists userID subscribes to, newest first.
+func (db *DB) GetSubscribedLists(userID int) ([]ListSummary, error) {
+	return db.getLists(userID, func(list List) bool {
+		_, ok := list.Subscribers[userID]
+		return ok
+	})
+}
+
+func (db *DB) getLists(viewerID int, include func(List) bool) ([]ListSummary, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	lists := []ListSummary{}
+	for _, list := range dbStructure.Lists {
+		if include(list) && canSeeList(dbStructure, list, viewerID) {
+			lists = append(lists, list.summary(viewerID))
+		}
+	}
+
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].ID > lists[j].ID
+	})
+
+	return lists, nil
+}
+
+// AddListMember puts memberID on a list ownerID owns. Nobody can be added
+// to a list across a block with its owner.
+func (db *DB) AddListMember(ownerID int, listID int, memberID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		list, err := ownedList(dbStructure, ownerID, listID)
+		if err != nil {
+			return err
+		}
+		if _, ok := dbStructure.Users[memberID]; !ok {
+			return ErrUserNotFound
+		}
+		if isBlocked(dbStructure, ownerID, memberID) {
+			return ErrBlocked
+		}
+		if _, ok := list.Members[memberID]; ok {
+			return errNoChanges
+		}
+		if len(list.Members) >= MaxListMembers {
+			return ErrListFull
+		}
+
+		list.Members[memberID] = time.Now().UTC()
+		dbStructure.Lists[listID] = list
+		return nil
+	})
+}
+
+func (db *DB) RemoveListMember(ownerID int, listID int, memberID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		list, err := ownedList(dbStructure, ownerID, listID)
+		if err != nil {
+			return err
+		}
+		if _, ok := list.Members[memberID]; !ok {
+			return errNoChanges
+		}
+
+		delete(list.Members, memberID)
+		dbStructure.Lists[listID] = list
+		return nil
+	})
+}
+
+// GetListMembers returns a list's members, most recently added first,
+// leaving out anyone blocked in either direction with viewerID.
+func (db *DB) GetListMembers(listID int, viewerID int) ([]Relation, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	list, ok := dbStructure.Lists[listID]
+	if !ok || !canSeeList(dbStructure, list, viewerID) {
+		return nil, ErrListNotFound
+	}
+
+	members := []Relation{}
+	for id, since := range list.Members {
+		if !isBlocked(dbStructure, viewerID, id) {
+			members = append(members, Relation{UserID: id, Since: since})
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Since.Equal(members[j].Since) {
+			return members[i].UserID < members[j].UserID
+		}
+		return members[i].Since.After(members[j].Since)
+	})
+
+	return members, nil
+}
+
+// SubscribeList adds or removes userID as a subscriber of a public list.
+func (db *DB) SubscribeList(userID int, listID int, subscribe bool) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		list, ok := dbStructure.Lists[listID]
+		if !ok || !canSeeList(dbStructure, list, userID) {
+			return ErrListNotFound
+		}
+		if list.OwnerID == userID {
+			return ErrOwnList
+		}
+
+		_, subscribed := list.Subscribers[userID]
+		if subscribed == subscribe {
+			return errNoChanges
+		}
+		if subscribe {
+			list.Subscribers[userID] = time.Now().UTC()
+		} else {
+			delete(list.Subscribers, userID)
+		}
+		dbStructure.Lists[listID] = list
+		return nil
+	})
+}
+
+// GetListTimeline returns up to limit chirps from a list's members, newest
+// first, starting below the chirp ID before (0 for the most recent). Members
+// viewerID has blocked, muted or been blocked by are left out, as is
+// anything viewerID may not read.
+func (db *DB) GetListTimeline(listID int, viewerID int, before int, limit int) ([]Chirp, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	list, ok := dbStructure.Lists[listID]
+	if !ok || !canSeeList(dbStructure, list, viewerID) {
+		return nil, ErrListNotFound
+	}
+
+	hidden := hiddenAuthors(dbStructure, viewerID)
+
+	indexes := [][]int{}
+	for member := range list.Members {
+		if !hidden[member] {
+			indexes = append(indexes, dbStructure.AuthorIndex[member])
+		}
+	}
+
+	return mergeIndexes(dbStructure, indexes, before, limit, viewerID, func(chirp Chirp) bool {
+		return !chirpHidden(dbStructure, chirp, hidden)
+	}), nil
+}
+
+// unlistBlocked removes each of two users from the other's lists and
+// subscriptions once one blocks the other.
+func unlistBlocked(dbStructure *DBStructure, a int, b int) {
+	for id, list := range dbStructure.Lists {
+		switch list.OwnerID {
+		case a:
+			delete(list.Members, b)
+			delete(list.Subscribers, b)
+		case b:
+			delete(list.Members, a)
+			delete(list.Subscribers, a)
+		default:
+			continue
+		}
+		dbStructure.Lists[id] = list
+	}
+}
+
+// forgetLists deletes a purged user's lists and takes them off everyone
+// else's.
+func forgetLists(dbStructure *DBStructure, userID int) {
+	for id, list := range dbStructure.Lists {
+		if list.OwnerID == userID {
+			delete(dbStructure.Lists, id)
+			continue
+		}
+		delete(list.Members, userID)
+		delete(list.Subscribers, userID)
+	}
+}
+
+// userLists returns the lists userID owns, oldest first.
+func userLists(dbStructure *DBStructure, userID int) []List {
+	owned := []List{}
+	for _, list := range dbStructure.Lists {
+		if list.OwnerID == userID {
+			owned = append(owned, list)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].ID < owned[j].ID
+	})
+	return owned
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+type LockoutEvent struct {
+	ID int `json:"id"`
+	Scope string `json:"scope"`
+	Subject string `json:"subject"`
+	IP string `json:"ip"`
+	Failures int `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) RecordLockoutEvent(event LockoutEvent) (LockoutEvent, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		event.ID = len(dbStructure.LockoutEvents) + 1
+		event.CreatedAt = time.Now()
+
+		dbStructure.LockoutEvents = append(dbStructure.LockoutEvents, event)
+		return nil
+	})
+	if err != nil {
+		return LockoutEvent{}, err
+	}
+
+	return event, nil
+}
+
+// GetLockoutEvents returns lockout events, most recent first.
+func (db *DB) GetLockoutEvents() ([]LockoutEvent, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	events := make([]LockoutEvent, 0, len(dbStructure.LockoutEvents))
+	for i := len(dbStructure.LockoutEvents) - 1; i >= 0; i-- {
+		events = append(events, dbStructure.LockoutEvents[i])
+	}
+
+	return events, nil
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxChirpMedia is how many uploads a single chirp may carry.
+const MaxChirpMedia = 4
+
+var ErrMediaNotFound = errors.New("Media not found")
+var ErrMediaQuota = errors.New("Media storage quota exceeded")
+var ErrMediaUnavailable = errors.New("Media does not exist, is not yours, or is already attached")
+var ErrTooManyMedia = fmt.Errorf("A chirp can have at most %d attachments", MaxChirpMedia)
+
+// Media is an uploaded image. BlobKey and ThumbnailKey name its files in the
+// blob store; several uploads of the same bytes share a blob. ChirpID is 0
+// until the upload is attached to a chirp.
+type Media struct {
+	ID int `json:"id"`
+	OwnerID int `json:"owner_id"`
+	ChirpID int `json:"chirp_id,omitempty"`
+	ContentType string `json:"content_type"`
+	Width int `json:"width"`
+	Height int `json:"height"`
+	Size int64 `json:"size"`
+	URL string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	BlobKey string `json:"blob_key"`
+	ThumbnailKey string `json:"thumbnail_key"`
+	ThumbnailSize int64 `json:"thumbnail_size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateMedia records a processed upload for its owner. It fails with
+// ErrMediaQuota if the owner's uploads would then take up more than quota
+// bytes; a quota of 0 means no limit.
+func (db *DB) CreateMedia(media Media, quota int64) (Media, error) {
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[media.OwnerID]; !ok {
+			return ErrUserNotFound
+		}
+
+		if quota > 0 && mediaUsage(dbStructure, media.OwnerID)+media.Size+media.ThumbnailSize > quota {
+			return ErrMediaQuota
+		}
+
+		dbStructure.LastMediaID++
+		media.ID = dbStructure.LastMediaID
+		media.ChirpID = 0
+		media.CreatedAt = time.Now().UTC()
+		dbStructure.Media[media.ID] = media
+		return nil
+	})
+	if err != nil {
+		return Media{}, err
+	}
+
+	return media, nil
+}
+
+// GetMediaUsage reports how many bytes of storage a user's uploads take up.
+func (db *DB) GetMediaUsage(userID int) (int64, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return 0, err
+	}
+
+	return mediaUsage(dbStructure, userID), nil
+}
+
+func mediaUsage(dbStructure *DBStructure, userID int) int64 {
+	var total int64
+	for _, m := range dbStructure.Media {
+		if m.OwnerID == userID {
+			total += m.Size + m.ThumbnailSize
+		}
+	}
+	return total
+}
+
+// DeleteMedia removes an upload that has not been attached to a chirp.
+// Attached media goes away with its chirp.
+func (db *DB) DeleteMedia(userID int, mediaID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		m, ok := dbStructure.Media[mediaID]
+		if !ok || m.OwnerID != userID {
+			return ErrMediaNotFound
+		}
+		if m.ChirpID != 0 || avatarMedia(dbStructure)[mediaID] {
+			return ErrMediaUnavailable
+		}
+
+		delete(dbStructure.Media, mediaID)
+		return nil
+	})
+}
+
+// PurgeOrphanMedia deletes uploads made before cutoff that were never
+// attached to a chirp, are not waiting in a draft and are not anyone's
+// avatar. It returns how many were removed.
+func (db *DB) PurgeOrphanMedia(cutoff time.Time) (int, error) {
+	removed := 0
+	err := db.update(func(dbStructure *DBStructure) error {
+		held := draftMedia(dbStructure)
+		for id := range avatarMedia(dbStructure) {
+			held[id] = true
+		}
+		for id, m := range dbStructure.Media {
+			if m.ChirpID == 0 && !held[id] && m.CreatedAt.Before(cutoff) {
+				delete(dbStructure.Media, id)
+				removed++
+			}
+		}
+
+		if removed == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// GetReferencedBlobs returns the keys of every blob still used by an upload.
+func (db *DB) GetReferencedBlobs() (map[string]bool, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for _, m := range dbStructure.Media {
+		keys[m.BlobKey] = true
+		keys[m.ThumbnailKey] = true
+	}
+	return keys, nil
+}
+
+// attachMedia claims the given uploads for a new chirp. Every upload must
+// belong to the author and not already be attached elsewhere or in use as
+// an avatar.
+func attachMedia(dbStructure *DBStructure, chirpID int, authorID int, mediaIDs []int) error {
+	if len(mediaIDs) > MaxChirpMedia {
+		return ErrTooManyMedia
+	}
+
+	avatars := avatarMedia(dbStructure)
+	seen := make(map[int]bool)
+	for _, id := range mediaIDs {
+		m, ok := dbStructure.Media[id]
+		if !ok || m.OwnerID != authorID || m.ChirpID != 0 || avatars[id] || seen[id] {
+			return ErrMediaUnavailable
+		}
+		seen[id] = true
+	}
+
+	for _, id := range mediaIDs {
+		m := dbStructure.Media[id]
+		m.ChirpID = chirpID
+		dbStructure.Media[id] = m
+	}
+	return nil
+}
+
+// chirpMedia looks up the attachments of a chirp in the order they were given.
+func chirpMedia(dbStructure *DBStructure, chirp Chirp) []Media {
+	if len(chirp.MediaIDs) == 0 {
+		return nil
+	}
+
+	attached := make([]Media, 0, len(chirp.MediaIDs))
+	for _, id := range chirp.MediaIDs {
+		if m, ok := dbStructure.Media[id]; ok {
+			attached = append(attached, m)
+		}
+	}
+	return attached
+}
@@ -0,0 +1,325 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MaxConversationMembers bounds group conversations, creator included.
+const MaxConversationMembers = 10
+
+var ErrConversationNotFound = errors.New("Conversation not found")
+var ErrInvalidMembers = fmt.Errorf("A conversation needs 2 to %d existing members", MaxConversationMembers)
+
+// Conversation is a private 1:1 or group thread. LastRead holds, per
+// member, the ID of the last message they have read.
+type Conversation struct {
+	ID int `json:"id"`
+	MemberIDs []int `json:"member_ids"`
+	CreatedBy int `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	LastRead map[int]int `json:"last_read"`
+}
+
+// Message is kept apart from chirps so it can never turn up in a public
+// listing.
+type Message struct {
+	ID int `json:"id"`
+	ConversationID int `json:"conversation_id"`
+	SenderID int `json:"sender_id"`
+	Body string `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConversationSummary is a conversation as listed for one member.
+type ConversationSummary struct {
+	ID int `json:"id"`
+	MemberIDs []int `json:"member_ids"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	LastMessage *Message `json:"last_message,omitempty"`
+	UnreadCount int `json:"unread_count"`
+}
+
+func (c Conversation) hasMember(userID int) bool {
+	for _, id := range c.MemberIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// setLastRead moves userID's read position. Conversations saved before
+// anyone had read them may have come back from disk without the map.
+func (c *Conversation) setLastRead(userID int, messageID int) {
+	if c.LastRead == nil {
+		c.LastRead = make(map[int]int)
+	}
+	c.LastRead[userID] = messageID
+}
+
+// CreateConversation starts a conversation between creatorID and
+// memberIDs. Asking for a 1:1 conversation that already exists returns it
+// with created set to false. The creator can't include anyone they have
+// blocked or been blocked by.
+func (db *DB) CreateConversation(creatorID int, memberIDs []int) (conversation Conversation, created bool, err error) {
+	err = db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[creatorID]; !ok {
+			return ErrUserNotFound
+		}
+
+		members := []int{creatorID}
+		seen := map[int]bool{creatorID: true}
+		for _, id := range memberIDs {
+			if seen[id] {
+				continue
+			}
+			if _, ok := dbStructure.Users[id]; !ok {
+				return ErrInvalidMembers
+			}
+			if isBlocked(dbStructure, creatorID, id) {
+				return ErrBlocked
+			}
+			seen[id] = true
+			members = append(members, id)
+		}
+		if len(members) < 2 || len(members) > MaxConversationMembers {
+			return ErrInvalidMembers
+		}
+		sort.Ints(members)
+
+		if len(members) == 2 {
+			for _, existing := range dbStructure.Conversations {
+				if len(existing.MemberIDs) == 2 && existing.MemberIDs[0] == members[0] && existing.MemberIDs[1] == members[1] {
+					conversation = existing
+					return errNoChanges
+				}
+			}
+		}
+
+		now := time.Now().UTC()
+		dbStructure.LastConversationID++
+		conversation = Conversation{
+			ID: dbStructure.LastConversationID,
+			MemberIDs: members,
+			CreatedBy: creatorID,
+			CreatedAt: now,
+			UpdatedAt: now,
+			LastRead: make(map[int]int),
+		}
+		dbStructure.Conversations[conversation.ID] = conversation
+		created = true
+		return nil
+	})
+	if err != nil {
+		return Conversation{}, false, err
+	}
+
+	return conversation, created, nil
+}
+
+// SendMessage adds a message with an already cleaned body. In a 1:1
+// conversation nothing can be sent once either member has blocked the
+// other; in a group, messages are hidden from members on either side of a
+// block instead.
+func (db *DB) SendMessage(senderID int, conversationID int, body string) (Message, error) {
+	var message Message
+	err := db.update(func(dbStructure *DBStructure) error {
+		conversation, ok := dbStructure.Conversations[conversationID]
+		if !ok || !conversation.hasMember(senderID) {
+			return ErrConversationNotFound
+		}
+		if len(conversation.MemberIDs) == 2 {
+			for _, id := range conversation.MemberIDs {
+				if isBlocked(dbStructure, senderID, id) {
+					return ErrBlocked
+				}
+			}
+		}
+
+		now := time.Now().UTC()
+		dbStructure.LastMessageID++
+		message = Message{
+			ID: dbStructure.LastMessageID,
+			ConversationID: conversationID,
+			SenderID: senderID,
+			Body: body,
+			CreatedAt: now,
+		}
+		dbStructure.Messages[conversationID] = append(dbStructure.Messages[conversationID], message)
+
+		// sending a message means having read everything before it
+		conversation.setLastRead(senderID, message.ID)
+		conversation.UpdatedAt = now
+		dbStructure.Conversations[conversationID] = conversation
+		return nil
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	return message, nil
+}
+
+// visibleMessages returns the messages in a conversation userID can see,
+// oldest first.
+func visibleMessages(dbStructure *DBStructure, conversationID int, userID int) []Message {
+	visible := []Message{}
+	for _, message := range dbStructure.Messages[conversationID] {
+		if !isBlocked(dbStructure, userID, message.SenderID) {
+			visible = append(visible, message)
+		}
+	}
+	return visible
+}
+
+// GetConversations lists userID's conversations, most recently active
+// first.
+func (db *DB) GetConversations(userID int) ([]ConversationSummary, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	summaries := []ConversationSummary{}
+	for _, conversation := range dbStructure.Conversations {
+		if !conversation.hasMember(userID) {
+			continue
+		}
+
+		summary := ConversationSummary{
+			ID: conversation.ID,
+			MemberIDs: conversation.MemberIDs,
+			CreatedAt: conversation.CreatedAt,
+			UpdatedAt: conversation.UpdatedAt,
+		}
+		messages := visibleMessages(dbStructure, conversation.ID, userID)
+		if len(messages) > 0 {
+			last := messages[len(messages)-1]
+			summary.LastMessage = &last
+		}
+		for _, message := range messages {
+			if message.ID > conversation.LastRead[userID] && message.SenderID != userID {
+				summary.UnreadCount++
+			}
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].UpdatedAt.Equal(summaries[j].UpdatedAt) {
+			return summaries[i].ID > summaries[j].ID
+		}
+		return summaries[i].UpdatedAt.After(summaries[j].UpdatedAt)
+	})
+
+	return summaries, nil
+}
+
+// GetMessages returns up to limit messages from a conversation, newest
+// first, starting below the message ID before (0 for the most recent).
+func (db *DB) GetMessages(userID int, conversationID int, before int, limit int) ([]Message, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	conversation, ok := dbStructure.Conversations[conversationID]
+	if !ok || !conversation.hasMember(userID) {
+		return nil, ErrConversationNotFound
+	}
+
+	messages := visibleMessages(dbStructure, conversationID, userID)
+	page := []Message{}
+	for i := len(messages) - 1; i >= 0 && len(page) < limit; i-- {
+		if before == 0 || messages[i].ID < before {
+			page = append(page, messages[i])
+		}
+	}
+
+	return page, nil
+}
+
+// MarkConversationRead records that userID has read up to and including
+// messageID, or everything when messageID is 0. The read position never
+// moves backwards.
+func (db *DB) MarkConversationRead(userID int, conversationID int, messageID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		conversation, ok := dbStructure.Conversations[conversationID]
+		if !ok || !conversation.hasMember(userID) {
+			return ErrConversationNotFound
+		}
+
+		latest := 0
+		if messages := dbStructure.Messages[conversationID]; len(messages) > 0 {
+			latest = messages[len(messages)-1].ID
+		}
+		if messageID == 0 || messageID > latest {
+			messageID = latest
+		}
+		if messageID <= conversation.LastRead[userID] {
+			return errNoChanges
+		}
+
+		conversation.setLastRead(userID, messageID)
+		dbStructure.Conversations[conversationID] = conversation
+		return nil
+	})
+}
+
+// leaveConversations removes a purged user from every conversation along
+// with the messages they sent. Conversations left with a single member are
+// deleted.
+func leaveConversations(dbStructure *DBStructure, userID int) {
+	for id, conversation := range dbStructure.Conversations {
+		if !conversation.hasMember(userID) {
+			continue
+		}
+
+		members := []int{}
+		for _, member := range conversation.MemberIDs {
+			if member != userID {
+				members = append(members, member)
+			}
+		}
+		if len(members) < 2 {
+			delete(dbStructure.Conversations, id)
+			delete(dbStructure.Messages, id)
+			continue
+		}
+
+		kept := []Message{}
+		for _, message := range dbStructure.Messages[id] {
+			if message.SenderID != userID {
+				kept = append(kept, message)
+			}
+		}
+		dbStructure.Messages[id] = kept
+
+		conversation.MemberIDs = members
+		delete(conversation.LastRead, userID)
+		dbStructure.Conversations[id] = conversation
+	}
+}
+
+// userMessages returns every message userID has sent, oldest first.
+func userMessages(dbStructure *DBStructure, userID int) []Message {
+	sent := []Message{}
+	for _, messages := range dbStructure.Messages {
+		for _, message := range messages {
+			if message.SenderID == userID {
+				sent = append(sent, message)
+			}
+		}
+	}
+	sort.Slice(sent, func(i, j int) bool {
+		return sent[i].ID < sent[j].ID
+	})
+	return sent
+}
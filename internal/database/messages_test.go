@@ -0,0 +1,95 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestFirstMessage(t *testing.T) {
+	db, err := NewDB(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := db.CreateUser("alice@example.com", "hash", RoleUser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := db.CreateUser("bob@example.com", "hash", RoleUser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, created, err := db.CreateConversation(alice.ID, []int{bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Fatal("CreateConversation reported an existing conversation")
+	}
+
+	// the conversation has been written and reloaded before anyone has
+	// read it
+	message, err := db.SendMessage(alice.ID, conversation.ID, "hi bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summaries, err := db.GetConversations(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].UnreadCount != 1 {
+		t.Fatalf("bob's conversations = %+v, want one with 1 unread", summaries)
+	}
+
+	if err := db.MarkConversationRead(bob.ID, conversation.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastRead := dbStructure.Conversations[conversation.ID].LastRead
+	if lastRead[alice.ID] != message.ID || lastRead[bob.ID] != message.ID {
+		t.Errorf("last read = %v, want both members at message %d", lastRead, message.ID)
+	}
+}
+
+func TestMessageToConversationSavedWithoutReads(t *testing.T) {
+	db, err := NewDB(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, err := db.CreateUser("alice@example.com", "hash", RoleUser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := db.CreateUser("bob@example.com", "hash", RoleUser, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation, _, err := db.CreateConversation(alice.ID, []int{bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// older files left the read positions out entirely
+	err = db.update(func(dbStructure *DBStructure) error {
+		c := dbStructure.Conversations[conversation.ID]
+		c.LastRead = nil
+		dbStructure.Conversations[conversation.ID] = c
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.SendMessage(bob.ID, conversation.ID, "hi alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MarkConversationRead(alice.ID, conversation.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,394 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	NotificationMention = "mention"
+	NotificationReply = "reply"
+	NotificationLike = "like"
+	NotificationFollow = "follow"
+	NotificationRechirp = "rechirp"
+)
+
+// NotificationTypes lists every notification type in a stable order.
+var NotificationTypes = []string{NotificationMention, NotificationReply, NotificationLike, NotificationFollow, NotificationRechirp}
+
+// MaxNotificationsPerUser bounds each inbox; the oldest notifications are
+// dropped first.
+const MaxNotificationsPerUser = 1000
+
+// maxGroupActors is how many actors a group names before it only counts
+// the rest.
+const maxGroupActors = 3
+
+var ErrUnknownNotificationType = errors.New("Unknown notification type")
+var ErrNotificationNotFound = errors.New("Notification not found")
+
+type Notification struct {
+	ID int `json:"id"`
+	Type string `json:"type"`
+	ActorID int `json:"actor_id"`
+	ChirpID int `json:"chirp_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Read bool `json:"read"`
+}
+
+// NotificationGroup folds likes and rechirps of the same chirp, and
+// follows, into a single entry while they are unread. Its ID is that of
+// its newest notification and doubles as the pagination cursor.
+type NotificationGroup struct {
+	ID int `json:"id"`
+	Type string `json:"type"`
+	ChirpID int `json:"chirp_id,omitempty"`
+	ActorIDs []int `json:"actor_ids"`
+	ActorCount int `json:"actor_count"`
+	Summary string `json:"summary"`
+	Read bool `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NotificationPage is one page of an inbox, newest first.
+type NotificationPage struct {
+	Notifications []NotificationGroup `json:"notifications"`
+	UnreadCount int `json:"unread_count"`
+	NextCursor int `json:"-"`
+}
+
+// groupKey decides which notifications fold together. Read and unread
+// notifications never share a group, so new activity isn't hidden inside
+// an old group.
+func (n Notification) groupKey() string {
+	switch n.Type {
+	case NotificationLike, NotificationRechirp, NotificationFollow:
+		return n.Type + ":" + strconv.Itoa(n.ChirpID) + ":" + strconv.FormatBool(n.Read)
+	}
+	return "id:" + strconv.Itoa(n.ID)
+}
+
+// notificationsEnabled reports whether userID wants notifications of the
+// given type. Every type is on until turned off.
+func notificationsEnabled(dbStructure *DBStructure, userID int, notificationType string) bool {
+	enabled, ok := dbStructure.NotificationPrefs[userID][notificationType]
+	return !ok || enabled
+}
+
+// notificationHidden reports whether recipientID should not hear from
+// actorID: either has blocked the other, or the recipient muted the actor.
+func notificationHidden(dbStructure *DBStructure, recipientID int, actorID int) bool {
+	if isBlocked(dbStructure, recipientID, actorID) {
+		return true
+	}
+	_, muted := dbStructure.Mutes[recipientID][actorID]
+	return muted
+}
+
+// notify adds a notification to recipientID's inbox unless it is about
+// their own action, comes from someone they don't want to hear from, or is
+// a type they have turned off.
+func notify(dbStructure *DBStructure, recipientID int, notificationType string, actorID int, chirpID int) {
+	if recipientID == actorID || recipientID == DeletedAuthorID {
+		return
+	}
+	if _, ok := dbStructure.Users[recipientID]; !ok {
+		return
+	}
+	if notificationHidden(dbStructure, recipientID, actorID) || !notificationsEnabled(dbStructure, recipientID, notificationType) {
+		return
+	}
+
+	dbStructure.LastNotificationID++
+	n := Notification{
+		ID: dbStructure.LastNotificationID,
+		Type: notificationType,
+		ActorID: actorID,
+		ChirpID: chirpID,
+		CreatedAt: time.Now().UTC(),
+	}
+	inbox := append(dbStructure.Notifications[recipientID], n)
+	if len(inbox) > MaxNotificationsPerUser {
+		inbox = inbox[len(inbox)-MaxNotificationsPerUser:]
+	}
+	dbStructure.Notifications[recipientID] = inbox
+
+	group := NotificationGroup{
+		ID: n.ID,
+		Type: n.Type,
+		ChirpID: n.ChirpID,
+		ActorIDs: []int{actorID},
+		ActorCount: 1,
+		CreatedAt: n.CreatedAt,
+	}
+	group.Summary = notificationSummary(dbStructure, group)
+	dbStructure.pendingNotifications = append(dbStructure.pendingNotifications, NotificationEvent{UserID: recipientID, Notification: group})
+}
+
+// notifyNewChirp tells the author of the chirp being replied to, and
+// anyone mentioned, about it, provided they can read it.
+func notifyNewChirp(dbStructure *DBStructure, chirp Chirp) {
+	notified := make(map[int]bool)
+
+	if chirp.InReplyTo != 0 {
+		if parent, ok := dbStructure.Chirps[chirp.InReplyTo]; ok && canView(dbStructure, chirp, parent.AuthorID) {
+			notify(dbStructure, parent.AuthorID, NotificationReply, chirp.AuthorID, chirp.ID)
+			notified[parent.AuthorID] = true
+		}
+	}
+
+	for _, mention := range chirp.Entities.Mentions {
+		if mention.UserID == 0 || notified[mention.UserID] || !canView(dbStructure, chirp, mention.UserID) {
+			continue
+		}
+		notify(dbStructure, mention.UserID, NotificationMention, chirp.AuthorID, chirp.ID)
+		notified[mention.UserID] = true
+	}
+}
+
+// retractNotification removes an unread notification when its action is
+// undone, such as an unlike or an unfollow.
+func retractNotification(dbStructure *DBStructure, recipientID int, notificationType string, actorID int, chirpID int) {
+	inbox := dbStructure.Notifications[recipientID]
+	for i, n := range inbox {
+		if !n.Read && n.Type == notificationType && n.ActorID == actorID && n.ChirpID == chirpID {
+			dbStructure.Notifications[recipientID] = append(inbox[:i:i], inbox[i+1:]...)
+			return
+		}
+	}
+}
+
+// forgetChirpNotifications drops every notification about a chirp that
+// no longer exists.
+func forgetChirpNotifications(dbStructure *DBStructure, chirpID int) {
+	for userID, inbox := range dbStructure.Notifications {
+		kept := inbox[:0]
+		for _, n := range inbox {
+			if n.ChirpID != chirpID {
+				kept = append(kept, n)
+			}
+		}
+		dbStructure.Notifications[userID] = kept
+	}
+}
+
+// forgetNotifications removes a purged user's inbox and everything they
+// caused in anyone else's.
+func forgetNotifications(dbStructure *DBStructure, userID int) {
+	delete(dbStructure.Notifications, userID)
+	delete(dbStructure.NotificationPrefs, userID)
+	for recipientID, inbox := range dbStructure.Notifications {
+		kept := inbox[:0]
+		for _, n := range inbox {
+			if n.ActorID != userID {
+				kept = append(kept, n)
+			}
+		}
+		dbStructure.Notifications[recipientID] = kept
+	}
+}
+
+// GetNotifications returns up to limit notification groups for userID,
+// newest first, starting below the notification ID before (0 for the
+// newest). Notifications from users the recipient has since blocked or
+// muted are left out.
+func (db *DB) GetNotifications(userID int, before int, limit int) (NotificationPage, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return NotificationPage{}, err
+	}
+
+	page := NotificationPage{Notifications: []NotificationGroup{}}
+	groups := make(map[string]*NotificationGroup)
+	// walking the inbox newest first leaves groups ordered by their newest
+	// notification
+	order := []*NotificationGroup{}
+
+	inbox := dbStructure.Notifications[userID]
+	for i := len(inbox) - 1; i >= 0; i-- {
+		n := inbox[i]
+		if notificationHidden(dbStructure, userID, n.ActorID) {
+			continue
+		}
+		if !n.Read {
+			page.UnreadCount++
+		}
+
+		key := n.groupKey()
+		group, ok := groups[key]
+		if !ok {
+			group = &NotificationGroup{
+				ID: n.ID,
+				Type: n.Type,
+				ChirpID: n.ChirpID,
+				ActorIDs: []int{},
+				Read: n.Read,
+				CreatedAt: n.CreatedAt,
+			}
+			groups[key] = group
+			order = append(order, group)
+		}
+		group.ActorCount++
+		if len(group.ActorIDs) < maxGroupActors {
+			group.ActorIDs = append(group.ActorIDs, n.ActorID)
+		}
+	}
+
+	for _, group := range order {
+		if before > 0 && group.ID >= before {
+			continue
+		}
+		if len(page.Notifications) == limit {
+			page.NextCursor = page.Notifications[limit-1].ID
+			break
+		}
+		group.Summary = notificationSummary(dbStructure, *group)
+		page.Notifications = append(page.Notifications, *group)
+	}
+
+	return page, nil
+}
+
+// notificationSummary describes a group in words, like "alice and 4
+// others liked your chirp".
+func notificationSummary(dbStructure *DBStructure, group NotificationGroup) string {
+	actor := "Someone"
+	if len(group.ActorIDs) > 0 {
+		if user, ok := dbStructure.Users[group.ActorIDs[0]]; ok && user.Handle != "" {
+			actor = "@" + user.Handle
+		}
+	}
+
+	switch others := group.ActorCount - 1; {
+	case others == 1:
+		actor += " and 1 other"
+	case others > 1:
+		actor += fmt.Sprintf(" and %d others", others)
+	}
+
+	switch group.Type {
+	case NotificationMention:
+		return actor + " mentioned you"
+	case NotificationReply:
+		return actor + " replied to your chirp"
+	case NotificationLike:
+		return actor + " liked your chirp"
+	case NotificationFollow:
+		return actor + " followed you"
+	case NotificationRechirp:
+		return actor + " rechirped your chirp"
+	}
+	return actor
+}
+
+// MarkNotificationsRead marks userID's notifications up to and including
+// upTo as read, or all of them when upTo is 0.
+func (db *DB) MarkNotificationsRead(userID int, upTo int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		changed := false
+		inbox := dbStructure.Notifications[userID]
+		for i := range inbox {
+			if !inbox[i].Read && (upTo == 0 || inbox[i].ID <= upTo) {
+				inbox[i].Read = true
+				changed = true
+			}
+		}
+		if !changed {
+			return errNoChanges
+		}
+		return nil
+	})
+}
+
+// MarkNotificationGroupRead marks the group whose newest notification is
+// notificationID as read.
+func (db *DB) MarkNotificationGroupRead(userID int, notificationID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		inbox := dbStructure.Notifications[userID]
+
+		key := ""
+		for _, n := range inbox {
+			if n.ID == notificationID {
+				key = n.groupKey()
+			}
+		}
+		if key == "" {
+			return ErrNotificationNotFound
+		}
+
+		changed := false
+		for i := range inbox {
+			if inbox[i].ID <= notificationID && inbox[i].groupKey() == key && !inbox[i].Read {
+				inbox[i].Read = true
+				changed = true
+			}
+		}
+		if !changed {
+			return errNoChanges
+		}
+		return nil
+	})
+}
+
+// GetNotificationPreferences returns whether each notification type is on
+// for userID.
+func (db *DB) GetNotificationPreferences(userID int) (map[string]bool, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	return notificationPreferences(dbStructure, userID), nil
+}
+
+// UpdateNotificationPreferences turns the given notification types on or
+// off and leaves the others as they were.
+func (db *DB) UpdateNotificationPreferences(userID int, changes map[string]bool) (map[string]bool, error) {
+	var prefs map[string]bool
+	err := db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return ErrUserNotFound
+		}
+
+		stored := dbStructure.NotificationPrefs[userID]
+		if stored == nil {
+			stored = make(map[string]bool)
+		}
+		for notificationType, enabled := range changes {
+			if !validNotificationType(notificationType) {
+				return ErrUnknownNotificationType
+			}
+			stored[notificationType] = enabled
+		}
+		dbStructure.NotificationPrefs[userID] = stored
+
+		prefs = notificationPreferences(dbStructure, userID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return prefs, nil
+}
+
+func notificationPreferences(dbStructure *DBStructure, userID int) map[string]bool {
+	prefs := make(map[string]bool)
+	for _, notificationType := range NotificationTypes {
+		prefs[notificationType] = notificationsEnabled(dbStructure, userID, notificationType)
+	}
+	return prefs
+}
+
+func validNotificationType(notificationType string) bool {
+	for _, t := range NotificationTypes {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MinPollOptions = 2
+	MaxPollOptions = 4
+	MaxPollOptionLength = 25
+)
+
+var ErrInvalidPoll = fmt.Errorf("A poll needs %d to %d distinct options of at most %d characters", MinPollOptions, MaxPollOptions, MaxPollOptionLength)
+var ErrNoPoll = errors.New("Chirp has no poll")
+var ErrPollClosed = errors.New("Poll is closed")
+var ErrAlreadyVoted = errors.New("Already voted in this poll")
+var ErrPollOption = errors.New("No such poll option")
+
+// PollOptions describes the poll to attach to a new chirp.
+type PollOptions struct {
+	Options []string
+	ExpiresAt time.Time
+}
+
+// StoredPoll is a poll as kept in the database, keyed by its chirp's ID.
+// Counts is kept alongside Voters so a purged voter can be forgotten
+// without changing the result of a poll that has already closed.
+type StoredPoll struct {
+	Options []string `json:"options"`
+	Counts []int `json:"counts"`
+	Voters map[int]int `json:"voters"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Finalized bool `json:"finalized"`
+}
+
+// Poll is what clients see. Votes and TotalVotes stay empty until the
+// viewer has voted or the poll has closed.
+type Poll struct {
+	Options []PollOption `json:"options"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Closed bool `json:"closed"`
+	TotalVotes *int `json:"total_votes,omitempty"`
+	ViewerVote *int `json:"viewer_vote,omitempty"`
+}
+
+type PollOption struct {
+	Text string `json:"text"`
+	Votes *int `json:"votes,omitempty"`
+}
+
+func (p StoredPoll) closed(now time.Time) bool {
+	return p.Finalized || !now.Before(p.ExpiresAt)
+}
+
+// newPoll validates opts. Option text is trimmed and must be unique,
+// ignoring case.
+func newPoll(opts PollOptions) (StoredPoll, error) {
+	if len(opts.Options) < MinPollOptions || len(opts.Options) > MaxPollOptions {
+		return StoredPoll{}, ErrInvalidPoll
+	}
+
+	poll := StoredPoll{
+		Counts: make([]int, len(opts.Options)),
+		Voters: make(map[int]int),
+		ExpiresAt: opts.ExpiresAt.UTC(),
+	}
+	seen := make(map[string]bool)
+	for _, option := range opts.Options {
+		option = strings.TrimSpace(option)
+		key := strings.ToLower(option)
+		if option == "" || utf8.RuneCountInString(option) > MaxPollOptionLength || seen[key] {
+			return StoredPoll{}, ErrInvalidPoll
+		}
+		seen[key] = true
+		poll.Options = append(poll.Options, option)
+	}
+
+	return poll, nil
+}
+
+// pollView builds the client view of a chirp's poll for viewerID, or nil if
+// the chirp has none.
+func pollView(dbStructure *DBStructure, chirpID int, viewerID int, now time.Time) *Poll {
+	stored, ok := dbStructure.Polls[chirpID]
+	if !ok {
+		return nil
+	}
+
+	poll := &Poll{
+		Options: make([]PollOption, len(stored.Options)),
+		ExpiresAt: stored.ExpiresAt,
+		Closed: stored.closed(now),
+	}
+
+	choice, voted := stored.Voters[viewerID]
+	if voted && viewerID != 0 {
+		poll.ViewerVote = &choice
+	}
+
+	showResults := poll.Closed || poll.ViewerVote != nil
+	total := 0
+	for i, text := range stored.Options {
+		poll.Options[i].Text = text
+		if showResults {
+			votes := stored.Counts[i]
+			poll.Options[i].Votes = &votes
+			total += votes
+		}
+	}
+	if showResults {
+		poll.TotalVotes = &total
+	}
+
+	return poll
+}
+
+// VotePoll records userID's vote for option (counted from 0) in the poll on
+// chirpID. Each user votes once and votes can't be changed.
+func (db *DB) VotePoll(userID int, chirpID int, option int) (Poll, error) {
+	var poll Poll
+	err := db.update(func(dbStructure *DBStructure) error {
+		chirpID = resolveRechirp(dbStructure, chirpID)
+
+		chirp, ok := dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted || !canView(dbStructure, chirp, userID) {
+			return ErrChirpID
+		}
+		stored, ok := dbStructure.Polls[chirpID]
+		if !ok {
+			return ErrNoPoll
+		}
+		if _, ok := dbStructure.Users[userID]; !ok {
+			return ErrUserNotFound
+		}
+		if isBlocked(dbStructure, userID, chirp.AuthorID) {
+			return ErrBlocked
+		}
+
+		now := time.Now()
+		if stored.closed(now) {
+			return ErrPollClosed
+		}
+		if _, ok := stored.Voters[userID]; ok {
+			return ErrAlreadyVoted
+		}
+		if option < 0 || option >= len(stored.Options) {
+			return ErrPollOption
+		}
+
+		stored.Voters[userID] = option
+		stored.Counts[option]++
+		dbStructure.Polls[chirpID] = stored
+
+		poll = *pollView(dbStructure, chirpID, userID, now)
+		return nil
+	})
+	if err != nil {
+		return Poll{}, err
+	}
+
+	return poll, nil
+}
+
+// FinalizePolls marks every poll that expired before now as closed and
+// returns the IDs of their chirps.
+func (db *DB) FinalizePolls(now time.Time) ([]int, error) {
+	closed := []int{}
+	err := db.update(func(dbStructure *DBStructure) error {
+		for chirpID, stored := range dbStructure.Polls {
+			if stored.Finalized || now.Before(stored.ExpiresAt) {
+				continue
+			}
+			stored.Finalized = true
+			dbStructure.Polls[chirpID] = stored
+			closed = append(closed, chirpID)
+
+			if chirp, ok := dbStructure.Chirps[chirpID]; ok {
+				dbStructure.emit(PollClosed, chirp, 0)
+			}
+		}
+		if len(closed) == 0 {
+			return errNoChanges
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return closed, nil
+}
+
+// forgetVoter removes a purged user's votes. Their votes still count in
+// polls that have already closed.
+func forgetVoter(dbStructure *DBStructure, userID int) {
+	now := time.Now()
+	for chirpID, stored := range dbStructure.Polls {
+		option, ok := stored.Voters[userID]
+		if !ok {
+			continue
+		}
+		delete(stored.Voters, userID)
+		if !stored.closed(now) {
+			stored.Counts[option]--
+		}
+		dbStructure.Polls[chirpID] = stored
+	}
+}
@@ -0,0 +1,281 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinHandleLength = 3
+	MaxHandleLength = 15
+	MaxDisplayNameLength = 50
+	MaxBioLength = 160
+)
+
+var ErrInvalidHandle = fmt.Errorf("Handles are %d to %d letters, digits or underscores and include at least one letter", MinHandleLength, MaxHandleLength)
+var ErrReservedHandle = errors.New("Handle is reserved")
+var ErrHandleTaken = errors.New("Handle is already taken")
+var ErrInvalidProfile = fmt.Errorf("Display names are limited to %d characters and bios to %d", MaxDisplayNameLength, MaxBioLength)
+
+// reservedHandles can't be claimed, so they can't be used to impersonate
+// the service or collide with its own routes.
+var reservedHandles = map[string]bool{
+	"about": true,
+	"admin": true,
+	"administrator": true,
+	"api": true,
+	"app": true,
+	"chirpy": true,
+	"deleted": true,
+	"everyone": true,
+	"export": true,
+	"help": true,
+	"here": true,
+	"me": true,
+	"media": true,
+	"moderator": true,
+	"null": true,
+	"root": true,
+	"security": true,
+	"settings": true,
+	"staff": true,
+	"support": true,
+	"system": true,
+	"undefined": true,
+}
+
+// Profile is the public view of a user. It never carries their email.
+type Profile struct {
+	ID int `json:"id"`
+	Handle string `json:"handle"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio string `json:"bio,omitempty"`
+	AvatarURL string `json:"avatar_url,omitempty"`
+	AvatarThumbnailURL string `json:"avatar_thumbnail_url,omitempty"`
+	IsChirpyRed bool `json:"is_chirpy_red"`
+	FollowerCount int `json:"follower_count"`
+	FollowingCount int `json:"following_count"`
+	ChirpCount int `json:"chirp_count"`
+}
+
+// ProfileUpdate changes the fields that are set and leaves the rest alone.
+// An empty display name or bio clears it, as does an avatar media ID of 0.
+type ProfileUpdate struct {
+	Handle *string
+	DisplayName *string
+	Bio *string
+	AvatarMediaID *int
+}
+
+// validateHandle checks the form of a handle, not whether it is free.
+func validateHandle(handle string) error {
+	if len(handle) < MinHandleLength || len(handle) > MaxHandleLength {
+		return ErrInvalidHandle
+	}
+
+	letters := 0
+	for _, r := range handle {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			letters++
+		case r >= '0' && r <= '9', r == '_':
+		default:
+			return ErrInvalidHandle
+		}
+	}
+	// all-digit handles would be mistaken for user IDs
+	if letters == 0 {
+		return ErrInvalidHandle
+	}
+
+	if reservedHandles[strings.ToLower(handle)] {
+		return ErrReservedHandle
+	}
+	return nil
+}
+
+// findUserByHandle matches handles without regard to case.
+func findUserByHandle(dbStructure *DBStructure, handle string) (User, bool) {
+	for _, user := range dbStructure.Users {
+		if user.Handle != "" && strings.EqualFold(user.Handle, handle) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// claimHandle checks that handle is valid and not held by anyone but userID.
+func claimHandle(dbStructure *DBStructure, userID int, handle string) error {
+	if err := validateHandle(handle); err != nil {
+		return err
+	}
+	if owner, ok := findUserByHandle(dbStructure, handle); ok && owner.ID != userID {
+		return ErrHandleTaken
+	}
+	return nil
+}
+
+// suggestHandle derives a free handle from an email address, for users who
+// didn't choose one.
+func suggestHandle(dbStructure *DBStructure, email string) string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+
+	var b strings.Builder
+	for _, r := range local {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' {
+			b.WriteRune(r)
+		}
+	}
+	base := b.String()
+	if len(base) > MaxHandleLength {
+		base = base[:MaxHandleLength]
+	}
+	// too short, or digits only
+	if validateHandle(base) == ErrInvalidHandle {
+		base = "user" + base
+		base = base[:min(len(base), MaxHandleLength)]
+	}
+
+	if claimHandle(dbStructure, 0, base) == nil {
+		return base
+	}
+	for n := 2; ; n++ {
+		suffix := strconv.Itoa(n)
+		candidate := base[:min(len(base), MaxHandleLength-len(suffix))] + suffix
+		if claimHandle(dbStructure, 0, candidate) == nil {
+			return candidate
+		}
+	}
+}
+
+// assignMissingHandles gives a handle to every user created before handles
+// existed.
+func (dbStructure *DBStructure) assignMissingHandles() {
+	for id, user := range dbStructure.Users {
+		if user.Handle == "" {
+			user.Handle = suggestHandle(dbStructure, user.Email)
+			dbStructure.Users[id] = user
+		}
+	}
+}
+
+// UpdateProfile applies update to userID's profile.
+func (db *DB) UpdateProfile(userID int, update ProfileUpdate) (User, error) {
+	return db.UpdateAccount(userID, User{}, update)
+}
+
+// UpdateAccount changes a user's email and password, from the non-empty
+// fields of updatedUser, along with their profile. Either everything is
+// applied or, when any part is rejected, nothing is.
+func (db *DB) UpdateAccount(userID int, updatedUser User, update ProfileUpdate) (User, error) {
+	var user User
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrUserNotFound
+		}
+
+		if err := applyProfileUpdate(dbStructure, &user, update); err != nil {
+			return err
+		}
+		if err := applyUserUpdate(dbStructure, &user, updatedUser); err != nil {
+			return err
+		}
+
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func applyProfileUpdate(dbStructure *DBStructure, user *User, update ProfileUpdate) error {
+	if update.Handle != nil {
+		if err := claimHandle(dbStructure, user.ID, *update.Handle); err != nil {
+			return err
+		}
+		user.Handle = *update.Handle
+	}
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		if utf8.RuneCountInString(name) > MaxDisplayNameLength {
+			return ErrInvalidProfile
+		}
+		user.DisplayName = name
+	}
+	if update.Bio != nil {
+		bio := strings.TrimSpace(*update.Bio)
+		if utf8.RuneCountInString(bio) > MaxBioLength {
+			return ErrInvalidProfile
+		}
+		user.Bio = bio
+	}
+	if update.AvatarMediaID != nil {
+		id := *update.AvatarMediaID
+		if id != 0 {
+			m, ok := dbStructure.Media[id]
+			if !ok || m.OwnerID != user.ID || m.ChirpID != 0 {
+				return ErrMediaUnavailable
+			}
+		}
+		user.AvatarMediaID = id
+	}
+	return nil
+}
+
+// GetProfile looks up a user by handle as seen by viewerID. Users who have
+// blocked each other can't see each other's profiles, and ChirpCount only
+// counts chirps the viewer may read.
+func (db *DB) GetProfile(handle string, viewerID int) (Profile, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return Profile{}, err
+	}
+
+	user, ok := findUserByHandle(dbStructure, handle)
+	if !ok || isBlocked(dbStructure, viewerID, user.ID) {
+		return Profile{}, ErrUserNotFound
+	}
+
+	profile := Profile{
+		ID: user.ID,
+		Handle: user.Handle,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		IsChirpyRed: user.IsChirpyRed,
+		FollowerCount: len(dbStructure.Followers[user.ID]),
+		FollowingCount: len(dbStructure.Following[user.ID]),
+	}
+	if m, ok := dbStructure.Media[user.AvatarMediaID]; ok {
+		profile.AvatarURL = m.URL
+		profile.AvatarThumbnailURL = m.ThumbnailURL
+	}
+
+	for _, id := range dbStructure.AuthorIndex[user.ID] {
+		chirp, ok := dbStructure.Chirps[id]
+		if ok && !chirp.Deleted && chirp.RechirpOf == 0 && canView(dbStructure, chirp, viewerID) {
+			profile.ChirpCount++
+		}
+	}
+
+	return profile, nil
+}
+
+// avatarMedia returns the media IDs in use as avatars.
+func avatarMedia(dbStructure *DBStructure) map[int]bool {
+	held := make(map[int]bool)
+	for _, user := range dbStructure.Users {
+		if user.AvatarMediaID != 0 {
+			held[user.AvatarMediaID] = true
+		}
+	}
+	return held
+}
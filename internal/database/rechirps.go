@@ -0,0 +1,130 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrRechirpNotFound = errors.New("Rechirp not found")
+
+// Rechirp shares chirpID into userID's timeline. Rechirping a rechirp shares
+// its original, and rechirping the same chirp twice returns the existing
+// rechirp with created set to false.
+func (db *DB) Rechirp(userID int, chirpID int) (rechirp Chirp, created bool, err error) {
+	err = db.update(func(dbStructure *DBStructure) error {
+		original, ok := dbStructure.Chirps[resolveRechirp(dbStructure, chirpID)]
+		if !ok || original.Deleted || !canView(dbStructure, original, userID) {
+			return ErrChirpID
+		}
+
+		if isBlocked(dbStructure, userID, original.AuthorID) {
+			return ErrBlocked
+		}
+
+		if original.Visibility == VisibilityFollowers {
+			return ErrNotShareable
+		}
+
+		if existing, ok := findRechirp(dbStructure, userID, original.ID); ok {
+			rechirp = hydrateChirp(dbStructure, existing)
+			return errNoChanges
+		}
+
+		original.RechirpCount++
+		dbStructure.Chirps[original.ID] = original
+
+		now := time.Now().UTC()
+		id := nextChirpID(dbStructure)
+
+		rechirp = Chirp{
+			ID: id,
+			AuthorID: userID,
+			CreatedAt: now,
+			UpdatedAt: now,
+			RechirpOf: original.ID,
+			Visibility: VisibilityPublic,
+		}
+		// sharing an unlisted chirp mustn't put it in public listings
+		if original.Visibility == VisibilityUnlisted {
+			rechirp.Visibility = VisibilityUnlisted
+		}
+		insertChirp(dbStructure, rechirp)
+		notify(dbStructure, original.AuthorID, NotificationRechirp, userID, original.ID)
+		dbStructure.emit(ChirpCreated, rechirp, userID)
+
+		rechirp = hydrateChirp(dbStructure, rechirp)
+		created = true
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, false, err
+	}
+
+	return rechirp, created, nil
+}
+
+// Unrechirp removes userID's rechirp of chirpID.
+func (db *DB) Unrechirp(userID int, chirpID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		rechirp, ok := findRechirp(dbStructure, userID, resolveRechirp(dbStructure, chirpID))
+		if !ok {
+			return ErrRechirpNotFound
+		}
+
+		removeChirp(dbStructure, rechirp.ID)
+		if original, ok := dbStructure.Chirps[rechirp.RechirpOf]; ok {
+			retractNotification(dbStructure, original.AuthorID, NotificationRechirp, userID, original.ID)
+		}
+		return nil
+	})
+}
+
+func findRechirp(dbStructure *DBStructure, userID int, originalID int) (Chirp, bool) {
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.RechirpOf == originalID && chirp.AuthorID == userID {
+			return chirp, true
+		}
+	}
+	return Chirp{}, false
+}
+
+// resolveRechirp maps a rechirp's ID to the ID of the chirp it shares. Any
+// other ID is returned unchanged.
+func resolveRechirp(dbStructure *DBStructure, chirpID int) int {
+	if chirp, ok := dbStructure.Chirps[chirpID]; ok && chirp.RechirpOf != 0 {
+		return chirp.RechirpOf
+	}
+	return chirpID
+}
+
+// hydrateChirp embeds the original of a rechirp or quote. An original that
+// has since been deleted is flagged rather than left dangling.
+func hydrateChirp(dbStructure *DBStructure, chirp Chirp) Chirp {
+	if chirp.Visibility == "" {
+		chirp.Visibility = VisibilityPublic
+	}
+	chirp.Media = chirpMedia(dbStructure, chirp)
+	chirp.Poll = pollView(dbStructure, chirp.ID, 0, time.Now())
+
+	originalID := chirp.RechirpOf
+	if originalID == 0 {
+		originalID = chirp.QuoteOf
+	}
+	if originalID == 0 {
+		return chirp
+	}
+
+	original, ok := dbStructure.Chirps[originalID]
+	if !ok || original.Deleted {
+		chirp.OriginalUnavailable = true
+		return chirp
+	}
+
+	if original.Visibility == "" {
+		original.Visibility = VisibilityPublic
+	}
+	original.Media = chirpMedia(dbStructure, original)
+	original.Poll = pollView(dbStructure, original.ID, 0, time.Now())
+	chirp.Original = &original
+	return chirp
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrEditWindowClosed = errors.New("Edit window has closed")
+
+// ChirpRevision is a body a chirp held before it was edited.
+type ChirpRevision struct {
+	Revision int `json:"revision"`
+	Body string `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// EditChirp replaces the body of a chirp authored by userID, keeping the
+// previous body as a revision. Edits are only accepted within editWindow of
+// the chirp being posted.
+func (db *DB) EditChirp(userID int, chirpID int, body string, editWindow time.Duration) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[chirpID]
+		if !ok || chirp.Deleted {
+			return ErrChirpID
+		}
+
+		if chirp.AuthorID != userID || chirp.RechirpOf != 0 {
+			return ErrAuthorization
+		}
+
+		now := time.Now().UTC()
+		if now.Sub(chirp.CreatedAt) > editWindow {
+			return ErrEditWindowClosed
+		}
+
+		revisions := dbStructure.ChirpRevisions[chirpID]
+		revisions = append(revisions, ChirpRevision{
+			Revision: len(revisions) + 1,
+			Body: chirp.Body,
+			CreatedAt: chirp.UpdatedAt,
+			ReplacedAt: now,
+		})
+		dbStructure.ChirpRevisions[chirpID] = revisions
+
+		unindexChirp(dbStructure, chirp)
+		chirp.Body = body
+		chirp.UpdatedAt = now
+		chirp.Entities = extractEntities(dbStructure, userID, body)
+		insertChirp(dbStructure, chirp)
+		dbStructure.emit(ChirpUpdated, chirp, userID)
+
+		chirp = hydrateChirp(dbStructure, chirp)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return chirp, nil
+}
+
+// GetChirpRevisions returns the prior bodies of a chirp, oldest first.
+func (db *DB) GetChirpRevisions(chirpID int) ([]ChirpRevision, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	if chirp, ok := dbStructure.Chirps[chirpID]; !ok || chirp.Deleted {
+		return nil, ErrChirpID
+	}
+
+	revisions := dbStructure.ChirpRevisions[chirpID]
+	if revisions == nil {
+		revisions = []ChirpRevision{}
+	}
+
+	return revisions, nil
+}
@@ -0,0 +1,265 @@
+package database
+
+import (
+	"fmt"
+	"internal/entities"
+	"sort"
+)
+
+type ThreadNode struct {
+	Chirp Chirp `json:"chirp"`
+	Replies []ThreadNode `json:"replies"`
+	MoreReplies int `json:"more_replies"`
+}
+
+type Thread struct {
+	Ancestors []Chirp `json:"ancestors"`
+	Chirp Chirp `json:"chirp"`
+	Replies []ThreadNode `json:"replies"`
+	NextCursor int `json:"next_cursor,omitempty"`
+}
+
+type ThreadOptions struct {
+	// ViewerID decides which authors are hidden from the thread.
+	ViewerID int
+	// Limit and After page through the direct replies to the chirp.
+	Limit int
+	After int
+	// Depth is how many levels of nested replies to expand, and
+	// ChildLimit how many replies to include at each nested level.
+	Depth int
+	ChildLimit int
+}
+
+// GetThread returns the chain of chirps leading to chirpID, root first,
+// and a page of the replies beneath it. Deleted chirps that still have
+// replies appear as tombstones so the tree stays connected.
+func (db *DB) GetThread(chirpID int, opts ThreadOptions) (Thread, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return Thread{}, err
+	}
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || isBlocked(dbStructure, opts.ViewerID, chirp.AuthorID) || !canView(dbStructure, chirp, opts.ViewerID) {
+		return Thread{}, ErrChirpID
+	}
+
+	hidden := hiddenAuthors(dbStructure, opts.ViewerID)
+
+	thread := Thread{
+		Ancestors: []Chirp{},
+		Chirp: viewChirp(dbStructure, chirp, opts.ViewerID),
+		Replies: []ThreadNode{},
+	}
+
+	// walk up the chain; the seen set guards against a corrupted cycle
+	seen := map[int]bool{chirpID: true}
+	for parentID := chirp.InReplyTo; parentID != 0 && !seen[parentID]; {
+		parent, ok := dbStructure.Chirps[parentID]
+		if !ok {
+			break
+		}
+		seen[parentID] = true
+		// hidden ancestors stay as placeholders so the chain is unbroken
+		if hidden[parent.AuthorID] || !canView(dbStructure, parent, opts.ViewerID) {
+			parent = Chirp{ID: parent.ID, InReplyTo: parent.InReplyTo, Unavailable: true}
+		} else {
+			parent = viewChirp(dbStructure, parent, opts.ViewerID)
+		}
+		thread.Ancestors = append([]Chirp{parent}, thread.Ancestors...)
+		parentID = parent.InReplyTo
+	}
+
+	children := make(map[int][]Chirp)
+	// replies from hidden authors, and replies the viewer may not read, are
+	// left out along with everything below them
+	for _, c := range dbStructure.Chirps {
+		if c.InReplyTo != 0 && !chirpHidden(dbStructure, c, hidden) && canView(dbStructure, c, opts.ViewerID) {
+			children[c.InReplyTo] = append(children[c.InReplyTo], c)
+		}
+	}
+	for id := range children {
+		replies := children[id]
+		sort.Slice(replies, func(i, j int) bool {
+			return replies[i].ID < replies[j].ID
+		})
+	}
+
+	direct := children[chirpID]
+	start := sort.Search(len(direct), func(i int) bool {
+		return direct[i].ID > opts.After
+	})
+	direct = direct[start:]
+
+	if opts.Limit > 0 && len(direct) > opts.Limit {
+		direct = direct[:opts.Limit]
+		thread.NextCursor = direct[len(direct)-1].ID
+	}
+
+	for _, reply := range direct {
+		thread.Replies = append(thread.Replies, buildThreadNode(dbStructure, children, reply, opts))
+	}
+
+	return thread, nil
+}
+
+func buildThreadNode(dbStructure *DBStructure, children map[int][]Chirp, chirp Chirp, opts ThreadOptions) ThreadNode {
+	node := ThreadNode{
+		Chirp: viewChirp(dbStructure, chirp, opts.ViewerID),
+		Replies: []ThreadNode{},
+	}
+
+	replies := children[chirp.ID]
+	if opts.Depth <= 0 {
+		node.MoreReplies = len(replies)
+		return node
+	}
+
+	if opts.ChildLimit > 0 && len(replies) > opts.ChildLimit {
+		node.MoreReplies = len(replies) - opts.ChildLimit
+		replies = replies[:opts.ChildLimit]
+	}
+
+	opts.Depth--
+	for _, reply := range replies {
+		node.Replies = append(node.Replies, buildThreadNode(dbStructure, children, reply, opts))
+	}
+
+	return node
+}
+
+// removeChirp deletes a chirp. A chirp that still has replies is kept as a
+// tombstone so its replies are not orphaned; removing the last reply to a
+// tombstone removes the tombstone as well. Rechirps of the chirp go with it,
+// while quotes keep their own body and show the original as unavailable.
+func removeChirp(dbStructure *DBStructure, chirpID int) {
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok {
+		return
+	}
+
+	delete(dbStructure.ChirpRevisions, chirpID)
+	delete(dbStructure.Likes, chirpID)
+	for _, id := range chirp.MediaIDs {
+		delete(dbStructure.Media, id)
+	}
+	delete(dbStructure.Polls, chirpID)
+	forgetChirpNotifications(dbStructure, chirpID)
+	dbStructure.emit(ChirpDeleted, chirp, 0)
+
+	for id, other := range dbStructure.Chirps {
+		if other.RechirpOf == chirpID {
+			unindexChirp(dbStructure, other)
+			delete(dbStructure.Chirps, id)
+			dbStructure.emit(ChirpDeleted, other, 0)
+		}
+	}
+
+	if chirp.RechirpOf != 0 {
+		adjustCount(dbStructure, chirp.RechirpOf, func(c *Chirp) { c.RechirpCount-- })
+	}
+	if chirp.QuoteOf != 0 {
+		adjustCount(dbStructure, chirp.QuoteOf, func(c *Chirp) { c.QuoteCount-- })
+	}
+
+	unindexChirp(dbStructure, chirp)
+
+	if chirp.ReplyCount > 0 {
+		// a tombstone only keeps its place in the thread; nothing of what
+		// was said, or when it was last edited, survives
+		scrubTombstone(&chirp)
+		chirp.AuthorID = DeletedAuthorID
+		chirp.Deleted = true
+		chirp.LikeCount = 0
+		chirp.RechirpCount = 0
+		chirp.QuoteOf = 0
+		chirp.MediaIDs = nil
+		dbStructure.Chirps[chirpID] = chirp
+		return
+	}
+
+	delete(dbStructure.Chirps, chirpID)
+
+	if chirp.InReplyTo == 0 {
+		return
+	}
+
+	parent, ok := dbStructure.Chirps[chirp.InReplyTo]
+	if !ok {
+		return
+	}
+	parent.ReplyCount--
+	dbStructure.Chirps[parent.ID] = parent
+
+	if parent.Deleted && parent.ReplyCount <= 0 {
+		parent.ReplyCount = 0
+		dbStructure.Chirps[parent.ID] = parent
+		removeChirp(dbStructure, parent.ID)
+	}
+}
+
+func scrubTombstone(chirp *Chirp) {
+	chirp.Body = ""
+	chirp.Entities = entities.Entities{}
+	chirp.UpdatedAt = chirp.CreatedAt
+}
+
+// scrubTombstones clears what tombstones written by older versions kept of
+// the deleted chirp.
+func (dbStructure *DBStructure) scrubTombstones() {
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.Deleted {
+			scrubTombstone(&chirp)
+			dbStructure.Chirps[id] = chirp
+		}
+	}
+}
+
+func adjustCount(dbStructure *DBStructure, chirpID int, adjust func(*Chirp)) {
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok {
+		return
+	}
+	adjust(&chirp)
+	dbStructure.Chirps[chirpID] = chirp
+}
+
+// ThreadChirpIDs returns chirpID and the IDs of every reply beneath it, so
+// a thread can be followed as it grows. It fails like GetThread when
+// viewerID may not read chirpID.
+func (db *DB) ThreadChirpIDs(chirpID int, viewerID int) (map[int]bool, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	chirp, ok := dbStructure.Chirps[chirpID]
+	if !ok || isBlocked(dbStructure, viewerID, chirp.AuthorID) || !canView(dbStructure, chirp, viewerID) {
+		return nil, ErrChirpID
+	}
+
+	children := make(map[int][]int)
+	for _, c := range dbStructure.Chirps {
+		if c.InReplyTo != 0 {
+			children[c.InReplyTo] = append(children[c.InReplyTo], c.ID)
+		}
+	}
+
+	ids := map[int]bool{chirpID: true}
+	queue := []int{chirpID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range children[id] {
+			if !ids[child] {
+				ids[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	return ids, nil
+}
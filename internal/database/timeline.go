@@ -0,0 +1,224 @@
+package database
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var ErrSelfFollow = errors.New("Users cannot follow themselves")
+
+type Relation struct {
+	UserID int `json:"user_id"`
+	Since time.Time `json:"since"`
+}
+
+// insertChirp stores a chirp and appends it to its author's index. IDs only
+// grow, so each index stays sorted oldest first without re-sorting.
+func insertChirp(dbStructure *DBStructure, chirp Chirp) {
+	dbStructure.Chirps[chirp.ID] = chirp
+
+	dbStructure.AuthorIndex[chirp.AuthorID] = addToIndex(dbStructure.AuthorIndex[chirp.AuthorID], chirp.ID)
+	for _, hashtag := range chirp.Entities.Hashtags {
+		dbStructure.HashtagIndex[hashtag.Tag] = addToIndex(dbStructure.HashtagIndex[hashtag.Tag], chirp.ID)
+	}
+}
+
+// unindexChirp drops a chirp from the author and hashtag indexes. The chirp
+// itself stays in the Chirps map.
+func unindexChirp(dbStructure *DBStructure, chirp Chirp) {
+	if ids := removeFromIndex(dbStructure.AuthorIndex[chirp.AuthorID], chirp.ID); len(ids) > 0 {
+		dbStructure.AuthorIndex[chirp.AuthorID] = ids
+	} else {
+		delete(dbStructure.AuthorIndex, chirp.AuthorID)
+	}
+
+	for _, hashtag := range chirp.Entities.Hashtags {
+		if ids := removeFromIndex(dbStructure.HashtagIndex[hashtag.Tag], chirp.ID); len(ids) > 0 {
+			dbStructure.HashtagIndex[hashtag.Tag] = ids
+		} else {
+			delete(dbStructure.HashtagIndex, hashtag.Tag)
+		}
+	}
+}
+
+func addToIndex(ids []int, id int) []int {
+	pos := sort.SearchInts(ids, id)
+	if pos < len(ids) && ids[pos] == id {
+		return ids
+	}
+	ids = append(ids, 0)
+	copy(ids[pos+1:], ids[pos:])
+	ids[pos] = id
+	return ids
+}
+
+func removeFromIndex(ids []int, id int) []int {
+	pos := sort.SearchInts(ids, id)
+	if pos == len(ids) || ids[pos] != id {
+		return ids
+	}
+	return append(ids[:pos], ids[pos+1:]...)
+}
+
+func (db *DB) FollowUser(followerID int, followeeID int) error {
+	if followerID == followeeID {
+		return ErrSelfFollow
+	}
+
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Users[followeeID]; !ok {
+			return ErrUserNotFound
+		}
+
+		if isBlocked(dbStructure, followerID, followeeID) {
+			return ErrBlocked
+		}
+
+		if _, ok := dbStructure.Following[followerID][followeeID]; ok {
+			return errNoChanges
+		}
+
+		now := time.Now().UTC()
+		if dbStructure.Following[followerID] == nil {
+			dbStructure.Following[followerID] = make(map[int]time.Time)
+		}
+		if dbStructure.Followers[followeeID] == nil {
+			dbStructure.Followers[followeeID] = make(map[int]time.Time)
+		}
+		dbStructure.Following[followerID][followeeID] = now
+		dbStructure.Followers[followeeID][followerID] = now
+		notify(dbStructure, followeeID, NotificationFollow, followerID, 0)
+		return nil
+	})
+}
+
+func (db *DB) UnfollowUser(followerID int, followeeID int) error {
+	return db.update(func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.Following[followerID][followeeID]; !ok {
+			return errNoChanges
+		}
+
+		delete(dbStructure.Following[followerID], followeeID)
+		delete(dbStructure.Followers[followeeID], followerID)
+		retractNotification(dbStructure, followeeID, NotificationFollow, followerID, 0)
+		return nil
+	})
+}
+
+// GetFollowers returns who follows userID, most recent first.
+func (db *DB) GetFollowers(userID int) ([]Relation, error) {
+	return db.getRelations(userID, func(d *DBStructure) map[int]map[int]time.Time { return d.Followers })
+}
+
+// GetFollowing returns who userID follows, most recent first.
+func (db *DB) GetFollowing(userID int) ([]Relation, error) {
+	return db.getRelations(userID, func(d *DBStructure) map[int]map[int]time.Time { return d.Following })
+}
+
+func (db *DB) getRelations(userID int, graph func(*DBStructure) map[int]map[int]time.Time) ([]Relation, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	if _, ok := dbStructure.Users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+
+	relations := []Relation{}
+	for id, since := range graph(dbStructure)[userID] {
+		relations = append(relations, Relation{UserID: id, Since: since})
+	}
+
+	sort.Slice(relations, func(i, j int) bool {
+		if relations[i].Since.Equal(relations[j].Since) {
+			return relations[i].UserID < relations[j].UserID
+		}
+		return relations[i].Since.After(relations[j].Since)
+	})
+
+	return relations, nil
+}
+
+// GetTimeline returns up to limit chirps from userID and the accounts they
+// follow, newest first, starting below the chirp ID before (0 for the most
+// recent). It merges the per-author indexes instead of scanning every chirp.
+func (db *DB) GetTimeline(userID int, before int, limit int) ([]Chirp, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return nil, err
+	}
+
+	hidden := hiddenAuthors(dbStructure, userID)
+
+	indexes := [][]int{dbStructure.AuthorIndex[userID]}
+	for followee := range dbStructure.Following[userID] {
+		if !hidden[followee] {
+			indexes = append(indexes, dbStructure.AuthorIndex[followee])
+		}
+	}
+
+	return mergeIndexes(dbStructure, indexes, before, limit, userID, func(chirp Chirp) bool {
+		return !chirpHidden(dbStructure, chirp, hidden)
+	}), nil
+}
+
+// mergeIndexes does a k-way merge of sorted chirp ID indexes, returning up
+// to limit chirps newest first from below the ID before. Only chirps that
+// viewerID may read and that pass keep are included.
+func mergeIndexes(dbStructure *DBStructure, indexes [][]int, before int, limit int, viewerID int, keep func(Chirp) bool) []Chirp {
+	h := &indexHeap{}
+	for _, ids := range indexes {
+		pos := len(ids)
+		if before > 0 {
+			pos = sort.SearchInts(ids, before)
+		}
+		if pos > 0 {
+			*h = append(*h, indexCursor{ids: ids, pos: pos - 1})
+		}
+	}
+	heap.Init(h)
+
+	chirps := []Chirp{}
+	for h.Len() > 0 && len(chirps) < limit {
+		top := &(*h)[0]
+		chirp, ok := dbStructure.Chirps[top.ids[top.pos]]
+		if ok && !chirp.Deleted && canView(dbStructure, chirp, viewerID) && keep(chirp) {
+			chirps = append(chirps, viewChirp(dbStructure, chirp, viewerID))
+		}
+
+		top.pos--
+		if top.pos < 0 {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+
+	return chirps
+}
+
+// indexCursor walks one author's index from newest to oldest.
+type indexCursor struct {
+	ids []int
+	pos int
+}
+
+type indexHeap []indexCursor
+
+func (h indexHeap) Len() int { return len(h) }
+func (h indexHeap) Less(i, j int) bool { return h[i].ids[h[i].pos] > h[j].ids[h[j].pos] }
+func (h indexHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *indexHeap) Push(x any) { *h = append(*h, x.(indexCursor)) }
+func (h *indexHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Visibility levels. Public chirps appear everywhere. Unlisted chirps can be
+// read by anyone who has their ID but are kept out of the public listing,
+// hashtag pages, search and trending. Followers-only chirps can only be read
+// by their author and the author's followers. Chirps stored before
+// visibility existed have none and count as public.
+const (
+	VisibilityPublic = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityFollowers = "followers"
+)
+
+var ErrInvalidVisibility = errors.New("visibility must be public, unlisted or followers")
+var ErrNotShareable = errors.New("Followers-only chirps cannot be rechirped")
+
+// normalizeVisibility defaults an empty visibility to public and rejects
+// unknown ones.
+func normalizeVisibility(visibility string) (string, error) {
+	switch visibility {
+	case "":
+		return VisibilityPublic, nil
+	case VisibilityPublic, VisibilityUnlisted, VisibilityFollowers:
+		return visibility, nil
+	}
+	return "", ErrInvalidVisibility
+}
+
+// canView reports whether viewerID, or an anonymous viewer when it is 0, may
+// read chirp. A rechirp is only as visible as the chirp it shares.
+func canView(dbStructure *DBStructure, chirp Chirp, viewerID int) bool {
+	if chirp.Visibility == VisibilityFollowers && viewerID != chirp.AuthorID {
+		if _, ok := dbStructure.Following[viewerID][chirp.AuthorID]; !ok {
+			return false
+		}
+	}
+
+	if chirp.RechirpOf != 0 {
+		if original, ok := dbStructure.Chirps[chirp.RechirpOf]; ok {
+			return canView(dbStructure, original, viewerID)
+		}
+	}
+
+	return true
+}
+
+// listed reports whether chirp belongs in listings that aren't scoped to
+// its author.
+func listed(chirp Chirp) bool {
+	return chirp.Visibility != VisibilityUnlisted
+}
+
+// viewChirp hydrates chirp for viewerID. A quoted original the viewer can't
+// read is shown as unavailable, the same as a deleted one.
+func viewChirp(dbStructure *DBStructure, chirp Chirp, viewerID int) Chirp {
+	chirp = hydrateChirp(dbStructure, chirp)
+	if chirp.Original != nil && !canView(dbStructure, *chirp.Original, viewerID) {
+		chirp.Original = nil
+		chirp.OriginalUnavailable = true
+	}
+	return chirp
+}
+
+// Viewer is a snapshot of whose chirps a user may read, for checking many
+// chirps without loading the database for each. It goes stale as follows,
+// blocks and mutes change, so holders should take a fresh one now and then.
+type Viewer struct {
+	ID int
+	following map[int]bool
+	hidden map[int]bool
+}
+
+// Viewer takes a snapshot for viewerID, or for an anonymous viewer when it
+// is 0.
+func (db *DB) Viewer(viewerID int) (Viewer, error) {
+	dbStructure, err := db.LoadDB()
+	if err != nil {
+		fmt.Println("Error loading db structure")
+		return Viewer{}, err
+	}
+
+	viewer := Viewer{
+		ID: viewerID,
+		following: make(map[int]bool),
+		hidden: hiddenAuthors(dbStructure, viewerID),
+	}
+	for id := range dbStructure.Following[viewerID] {
+		viewer.following[id] = true
+	}
+
+	return viewer, nil
+}
+
+// Follows reports whether the viewer followed userID when the snapshot was
+// taken.
+func (v Viewer) Follows(userID int) bool {
+	return v.following[userID]
+}
+
+// CanSee applies the rules of canView and chirpHidden to a chirp hydrated
+// the way events carry it, with any original attached.
+func (v Viewer) CanSee(chirp Chirp) bool {
+	if v.hidden[chirp.AuthorID] || !v.canRead(chirp) {
+		return false
+	}
+	if chirp.Original != nil {
+		if v.hidden[chirp.Original.AuthorID] {
+			return false
+		}
+		if chirp.RechirpOf != 0 && !v.canRead(*chirp.Original) {
+			return false
+		}
+	}
+	return true
+}
+
+// View blanks a quoted original the viewer can't read, as viewChirp does.
+func (v Viewer) View(chirp Chirp) Chirp {
+	if chirp.Original != nil && !v.canRead(*chirp.Original) {
+		chirp.Original = nil
+		chirp.OriginalUnavailable = true
+	}
+	return chirp
+}
+
+func (v Viewer) canRead(chirp Chirp) bool {
+	return chirp.Visibility != VisibilityFollowers || chirp.AuthorID == v.ID || v.following[chirp.AuthorID]
+}
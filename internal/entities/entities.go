@@ -0,0 +1,132 @@
+package entities
+
+import (
+	"strings"
+	"unicode"
+)
+
+const maxMentionLength = 30
+
+// Offsets are in Unicode code points, with End exclusive, so clients can
+// slice the body they received without knowing how it was encoded.
+type Mention struct {
+	Username string `json:"username"`
+	UserID int `json:"user_id,omitempty"`
+	Start int `json:"start"`
+	End int `json:"end"`
+}
+
+type Hashtag struct {
+	Tag string `json:"tag"`
+	Start int `json:"start"`
+	End int `json:"end"`
+}
+
+type URL struct {
+	URL string `json:"url"`
+	Start int `json:"start"`
+	End int `json:"end"`
+}
+
+type Entities struct {
+	Mentions []Mention `json:"mentions"`
+	Hashtags []Hashtag `json:"hashtags"`
+	URLs []URL `json:"urls"`
+}
+
+// Extract finds @mentions, #hashtags and http(s) URLs in body. It should be
+// run on the body as stored, after any rewriting, so offsets line up.
+func Extract(body string) Entities {
+	runes := []rune(body)
+	found := Entities{
+		Mentions: []Mention{},
+		Hashtags: []Hashtag{},
+		URLs: []URL{},
+	}
+
+	for i := 0; i < len(runes); {
+		if end := matchURL(runes, i); end > i {
+			found.URLs = append(found.URLs, URL{URL: string(runes[i:end]), Start: i, End: end})
+			i = end
+			continue
+		}
+
+		if runes[i] == '@' && boundaryBefore(runes, i) {
+			end := i + 1
+			for end < len(runes) && isMentionRune(runes[end]) && end-i <= maxMentionLength {
+				end++
+			}
+			if end > i+1 && (end == len(runes) || !isMentionRune(runes[end])) {
+				found.Mentions = append(found.Mentions, Mention{Username: strings.ToLower(string(runes[i+1 : end])), Start: i, End: end})
+				i = end
+				continue
+			}
+		}
+
+		if runes[i] == '#' && boundaryBefore(runes, i) {
+			end := i + 1
+			hasLetter := false
+			for end < len(runes) && isWordRune(runes[end]) {
+				if unicode.IsLetter(runes[end]) {
+					hasLetter = true
+				}
+				end++
+			}
+			if hasLetter {
+				found.Hashtags = append(found.Hashtags, Hashtag{Tag: NormalizeHashtag(string(runes[i+1 : end])), Start: i, End: end})
+				i = end
+				continue
+			}
+		}
+
+		i++
+	}
+
+	return found
+}
+
+// NormalizeHashtag is the form tags are indexed and looked up by.
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func matchURL(runes []rune, i int) int {
+	if !boundaryBefore(runes, i) {
+		return i
+	}
+
+	rest := string(runes[i:min(len(runes), i+8)])
+	var scheme int
+	if strings.HasPrefix(strings.ToLower(rest), "https://") {
+		scheme = 8
+	} else if strings.HasPrefix(strings.ToLower(rest), "http://") {
+		scheme = 7
+	} else {
+		return i
+	}
+
+	end := i + scheme
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+	}
+	// trailing punctuation almost always belongs to the sentence, not the link
+	for end > i+scheme && strings.ContainsRune(".,!?;:)]}'\"", runes[end-1]) {
+		end--
+	}
+	if end == i+scheme {
+		return i
+	}
+	return end
+}
+
+func boundaryBefore(runes []rune, i int) bool {
+	return i == 0 || !isWordRune(runes[i-1]) && runes[i-1] != '@' && runes[i-1] != '#'
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isMentionRune(r rune) bool {
+	return r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
@@ -0,0 +1,3 @@
+module entities
+
+go 1.22.0
@@ -0,0 +1,333 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedType = errors.New("only JPEG, PNG and GIF images are accepted")
+	ErrImageTooLarge = errors.New("image dimensions are too large")
+	ErrTooManyFrames = errors.New("animation has too many frames")
+	ErrInvalidImage = errors.New("image could not be decoded")
+)
+
+// Limits bound the work done on an upload.
+type Limits struct {
+	// MaxPixels caps width times height, checked before the image is
+	// decoded. For an animated GIF it caps the total over every frame.
+	MaxPixels int
+	// MaxFrames caps the frames in an animated GIF
+	MaxFrames int
+	// ThumbnailSize is the longest edge of a generated thumbnail
+	ThumbnailSize int
+}
+
+// Image is an upload after processing. Data is the re-encoded original,
+// which no longer carries EXIF or other metadata.
+type Image struct {
+	Data []byte
+	ContentType string
+	Width int
+	Height int
+	Thumbnail []byte
+	ThumbnailType string
+}
+
+// Process sniffs data, rejects anything that is not a supported image, and
+// re-encodes it to drop embedded metadata. JPEG orientation is applied to
+// the pixels first so photos keep the right way up once the tag is gone.
+func Process(data []byte, limits Limits) (Image, error) {
+	contentType := http.DetectContentType(data)
+	if contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/gif" {
+		return Image{}, ErrUnsupportedType
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || "image/"+format != contentType {
+		return Image{}, ErrInvalidImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > limits.MaxPixels {
+		return Image{}, ErrImageTooLarge
+	}
+
+	result := Image{ContentType: contentType}
+	var buf bytes.Buffer
+	var first image.Image
+
+	switch contentType {
+	case "image/gif":
+		// every frame is decoded in full, so the animation as a whole has
+		// to fit the limits before DecodeAll allocates anything
+		frames, pixels, err := gifFrames(data)
+		if err != nil {
+			return Image{}, ErrInvalidImage
+		}
+		if frames > limits.MaxFrames {
+			return Image{}, ErrTooManyFrames
+		}
+		if pixels > limits.MaxPixels {
+			return Image{}, ErrImageTooLarge
+		}
+
+		// keep every frame of an animation; GIFs carry no EXIF
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, ErrInvalidImage
+		}
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			return Image{}, err
+		}
+		first = g.Image[0]
+		result.Width, result.Height = g.Config.Width, g.Config.Height
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, ErrInvalidImage
+		}
+		if err := png.Encode(&buf, img); err != nil {
+			return Image{}, err
+		}
+		first = img
+		result.Width, result.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	default:
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return Image{}, ErrInvalidImage
+		}
+		img = orient(toRGBA(img), jpegOrientation(data))
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return Image{}, err
+		}
+		first = img
+		result.Width, result.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+
+	result.Data = buf.Bytes()
+
+	thumb := thumbnail(toRGBA(first), limits.ThumbnailSize)
+	buf = bytes.Buffer{}
+	if contentType == "image/jpeg" {
+		result.ThumbnailType = "image/jpeg"
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+	} else {
+		// PNG and GIF may be transparent, which JPEG can't keep
+		result.ThumbnailType = "image/png"
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return Image{}, err
+	}
+	result.Thumbnail = buf.Bytes()
+
+	return result, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// thumbnail shrinks src to fit within size by size, averaging the source
+// pixels behind each output pixel. Images already small enough are
+// returned as they are.
+func thumbnail(src *image.RGBA, size int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sw <= size && sh <= size {
+		return src
+	}
+
+	dw, dh := size, sh*size/sw
+	if sh > sw {
+		dw, dh = sw*size/sh, size
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+
+			n := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+// orient turns src upright according to an EXIF orientation value (1-8).
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	// source pixel for each destination pixel
+	lookup := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return w - 1 - x, y },
+		3: func(x, y int) (int, int) { return w - 1 - x, h - 1 - y },
+		4: func(x, y int) (int, int) { return x, h - 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return y, h - 1 - x },
+		7: func(x, y int) (int, int) { return w - 1 - y, h - 1 - x },
+		8: func(x, y int) (int, int) { return w - 1 - y, x },
+	}[orientation]
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := lookup(x, y)
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the orientation tag from a JPEG's EXIF block. It
+// returns 1, meaning upright, when there is no tag or the block is damaged.
+func jpegOrientation(data []byte) int {
+	// walk the marker segments up to the start of the image data
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
+
+// gifFrames walks a GIF's blocks without decoding any pixels and returns
+// how many frames it has and their combined area.
+func gifFrames(data []byte) (frames int, pixels int, err error) {
+	errTruncated := errors.New("gif: truncated")
+
+	// header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, 0, errTruncated
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skipSubBlocks steps over data sub-blocks up to their terminator
+	skipSubBlocks := func() error {
+		for {
+			if i >= len(data) {
+				return errTruncated
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	for {
+		// a file cut off between blocks is left for the decoder to judge
+		if i >= len(data) {
+			return frames, pixels, nil
+		}
+		switch data[i] {
+		case 0x21:
+			// extension: introducer, label, then sub-blocks
+			i += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C:
+			// image descriptor, optional local color table, LZW code size
+			if i+10 > len(data) {
+				return 0, 0, errTruncated
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int(binary.LittleEndian.Uint16(data[i+7:]))
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+			if err := skipSubBlocks(); err != nil {
+				return 0, 0, err
+			}
+			frames++
+			pixels += width * height
+		case 0x3B:
+			return frames, pixels, nil
+		default:
+			return 0, 0, errors.New("gif: unknown block")
+		}
+	}
+}
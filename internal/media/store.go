@@ -0,0 +1,124 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store keeps blobs on disk named by the SHA-256 of their contents, so the
+// same bytes uploaded twice are stored once. Blobs live two levels deep
+// (ab/abcdef...) to keep directories small.
+type Store struct {
+	dir string
+}
+
+// Blob is a stored object as found by List.
+type Blob struct {
+	Key string
+	Size int64
+	ModTime time.Time
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Dir is where blobs are kept, so callers can keep it out of other listings.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func validKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key)
+}
+
+// Put stores data and returns its key. Writing bytes that are already stored
+// only refreshes the blob's modification time.
+func (s *Store) Put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	p := s.path(key)
+
+	if _, err := os.Stat(p); err == nil {
+		now := time.Now()
+		return key, os.Chtimes(p, now, now)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+
+	// write then rename so readers never see a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(p), key+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	return key, os.Rename(tmp.Name(), p)
+}
+
+func (s *Store) Open(key string) (*os.File, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	return os.Open(s.path(key))
+}
+
+// Delete removes a blob. Deleting a blob that does not exist is not an error.
+func (s *Store) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List returns every blob in the store.
+func (s *Store) List() ([]Blob, error) {
+	blobs := []Blob{}
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !validKey(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, Blob{Key: d.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return blobs, err
+}
@@ -0,0 +1,3 @@
+module password
+
+go 1.22.0
@@ -0,0 +1,171 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt = "bcrypt"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+var ErrInvalidHash = errors.New("invalid encoded password hash")
+var ErrMismatch = errors.New("password does not match hash")
+
+type Params struct {
+	Algorithm string
+	Memory uint32
+	Iterations uint32
+	Parallelism uint8
+	SaltLength uint32
+	KeyLength uint32
+	BcryptCost int
+}
+
+// DefaultParams follows the OWASP baseline for argon2id.
+func DefaultParams() Params {
+	return Params{
+		Algorithm: Argon2id,
+		Memory: 64 * 1024,
+		Iterations: 3,
+		Parallelism: 2,
+		SaltLength: 16,
+		KeyLength: 32,
+		BcryptCost: 12,
+	}
+}
+
+type Hasher struct {
+	params Params
+}
+
+func NewHasher(params Params) (*Hasher, error) {
+	switch params.Algorithm {
+	case Argon2id:
+		if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+			return nil, errors.New("argon2id memory, iterations and parallelism must be positive")
+		}
+	case Bcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+	return &Hasher{params: params}, nil
+}
+
+// Hash encodes password with the current policy. Argon2id hashes use the
+// PHC string format so their parameters travel with the hash.
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.params.Algorithm == Bcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashed), nil
+	}
+
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.params.Memory,
+		h.params.Iterations,
+		h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against an encoded hash of either algorithm. When
+// the password matches, needsRehash reports whether the hash was produced
+// under a different policy than the current one.
+func (h *Hasher) Verify(password string, encoded string) (needsRehash bool, err error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(key, other) != 1 {
+			return false, ErrMismatch
+		}
+
+		current := h.params
+		stale := current.Algorithm != Argon2id ||
+			params.Memory != current.Memory ||
+			params.Iterations != current.Iterations ||
+			params.Parallelism != current.Parallelism ||
+			params.KeyLength != current.KeyLength ||
+			params.SaltLength != current.SaltLength
+		return stale, nil
+	}
+
+	if strings.HasPrefix(encoded, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, ErrMismatch
+		}
+		if err != nil {
+			return false, err
+		}
+
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, err
+		}
+		return h.params.Algorithm != Bcrypt || cost != h.params.BcryptCost, nil
+	}
+
+	return false, ErrUnknownAlgorithm
+}
+
+func decodeArgon2id(encoded string) (Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	if version != argon2.Version {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	params := Params{Algorithm: Argon2id}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
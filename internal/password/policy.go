@@ -0,0 +1,70 @@
+package password
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrCommonPassword = errors.New("password is too common")
+
+type TooShortError struct {
+	MinLength int
+}
+
+func (e TooShortError) Error() string {
+	return fmt.Sprintf("password must be at least %d characters", e.MinLength)
+}
+
+type Policy struct {
+	MinLength int
+	common map[string]struct{}
+}
+
+// LoadPolicy builds a policy whose blocklist is read from path, one password
+// per line. An empty path disables the blocklist.
+func LoadPolicy(minLength int, path string) (*Policy, error) {
+	policy := Policy{
+		MinLength: minLength,
+		common: make(map[string]struct{}),
+	}
+
+	if path == "" {
+		return &policy, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		policy.common[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+func (p *Policy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return TooShortError{MinLength: p.MinLength}
+	}
+
+	if _, ok := p.common[strings.ToLower(password)]; ok {
+		return ErrCommonPassword
+	}
+
+	return nil
+}
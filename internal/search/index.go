@@ -0,0 +1,327 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BM25 tuning; these are the usual defaults.
+const (
+	k1 = 1.2
+	b = 0.75
+)
+
+// maxExpansions caps how many indexed terms a single prefix may stand for.
+const maxExpansions = 64
+
+// Document is a piece of text to index along with the metadata searches
+// can filter on. QuotedAuthorID is the author of a quoted chirp, so that
+// hiding an author also hides quotes of them.
+type Document struct {
+	ID int
+	Body string
+	AuthorID int
+	QuotedAuthorID int
+	CreatedAt time.Time
+}
+
+type document struct {
+	authorID int
+	quotedAuthorID int
+	createdAt time.Time
+	length int
+	terms []string
+}
+
+// Options filter and page a search. Zero values mean no restriction.
+type Options struct {
+	AuthorID int
+	Since time.Time
+	Until time.Time
+	ExcludeAuthors map[int]bool
+	Limit int
+	Offset int
+}
+
+type Hit struct {
+	ID int
+	Score float64
+}
+
+// Result holds one page of hits, best first, and how many matched overall.
+type Result struct {
+	Hits []Hit
+	Total int
+}
+
+// Index is an in-memory inverted index mapping each term to the documents
+// containing it and the positions it appears at.
+type Index struct {
+	mutex sync.RWMutex
+	postings map[string]map[int][]int
+	docs map[int]*document
+	totalLength int
+
+	// sortedTerms backs prefix lookups and is rebuilt lazily after changes
+	sortedTerms []string
+	termsDirty bool
+}
+
+func NewIndex() *Index {
+	return &Index{
+		postings: make(map[string]map[int][]int),
+		docs: make(map[int]*document),
+	}
+}
+
+// Add indexes doc, replacing any earlier version with the same ID.
+func (idx *Index) Add(doc Document) {
+	tokens := Tokenize(doc.Body)
+
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.remove(doc.ID)
+	if len(tokens) == 0 {
+		return
+	}
+
+	d := &document{
+		authorID: doc.AuthorID,
+		quotedAuthorID: doc.QuotedAuthorID,
+		createdAt: doc.CreatedAt,
+		length: len(tokens),
+	}
+
+	for pos, term := range tokens {
+		docs, ok := idx.postings[term]
+		if !ok {
+			docs = make(map[int][]int)
+			idx.postings[term] = docs
+			idx.termsDirty = true
+		}
+		if _, seen := docs[doc.ID]; !seen {
+			d.terms = append(d.terms, term)
+		}
+		docs[doc.ID] = append(docs[doc.ID], pos)
+	}
+
+	idx.docs[doc.ID] = d
+	idx.totalLength += d.length
+}
+
+func (idx *Index) Remove(id int) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.remove(id)
+}
+
+func (idx *Index) remove(id int) {
+	d, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+
+	for _, term := range d.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+			idx.termsDirty = true
+		}
+	}
+
+	idx.totalLength -= d.length
+	delete(idx.docs, id)
+}
+
+// Len reports how many documents are indexed.
+func (idx *Index) Len() int {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return len(idx.docs)
+}
+
+// Search returns the documents matching every part of q, ranked by BM25.
+// Ties go to the higher, that is newer, ID.
+func (idx *Index) Search(q Query, opts Options) Result {
+	// prefix expansion may rebuild the sorted term list, so take the write lock
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	// each group is a set of terms of which a document must contain at least one
+	groups := [][]string{}
+	for _, term := range q.Terms {
+		groups = append(groups, []string{term})
+	}
+	for _, prefix := range q.Prefixes {
+		groups = append(groups, idx.expand(prefix))
+	}
+	for _, phrase := range q.Phrases {
+		for _, term := range phrase {
+			groups = append(groups, []string{term})
+		}
+	}
+
+	candidates := idx.intersect(groups)
+
+	hits := []Hit{}
+	for id := range candidates {
+		d := idx.docs[id]
+		if !opts.matches(d) || !idx.hasPhrases(id, q.Phrases) {
+			continue
+		}
+		hits = append(hits, Hit{ID: id, Score: idx.score(id, d, groups)})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+
+	result := Result{Total: len(hits)}
+	start := min(opts.Offset, len(hits))
+	end := len(hits)
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, len(hits))
+	}
+	result.Hits = hits[start:end]
+
+	return result
+}
+
+func (opts Options) matches(d *document) bool {
+	if opts.AuthorID != 0 && d.authorID != opts.AuthorID {
+		return false
+	}
+	if opts.ExcludeAuthors[d.authorID] || (d.quotedAuthorID != 0 && opts.ExcludeAuthors[d.quotedAuthorID]) {
+		return false
+	}
+	if !opts.Since.IsZero() && d.createdAt.Before(opts.Since) {
+		return false
+	}
+	if !opts.Until.IsZero() && !d.createdAt.Before(opts.Until) {
+		return false
+	}
+	return true
+}
+
+// expand returns the indexed terms starting with prefix, most common first.
+// Caller must hold the write lock.
+func (idx *Index) expand(prefix string) []string {
+	if idx.termsDirty || idx.sortedTerms == nil {
+		idx.sortedTerms = make([]string, 0, len(idx.postings))
+		for term := range idx.postings {
+			idx.sortedTerms = append(idx.sortedTerms, term)
+		}
+		sort.Strings(idx.sortedTerms)
+		idx.termsDirty = false
+	}
+
+	terms := []string{}
+	for i := sort.SearchStrings(idx.sortedTerms, prefix); i < len(idx.sortedTerms); i++ {
+		if !strings.HasPrefix(idx.sortedTerms[i], prefix) {
+			break
+		}
+		terms = append(terms, idx.sortedTerms[i])
+	}
+
+	if len(terms) > maxExpansions {
+		sort.SliceStable(terms, func(i, j int) bool {
+			return len(idx.postings[terms[i]]) > len(idx.postings[terms[j]])
+		})
+		terms = terms[:maxExpansions]
+	}
+
+	return terms
+}
+
+// intersect returns the documents containing at least one term from every
+// group.
+func (idx *Index) intersect(groups [][]string) map[int]bool {
+	sets := make([]map[int]bool, 0, len(groups))
+	for _, group := range groups {
+		set := make(map[int]bool)
+		for _, term := range group {
+			for id := range idx.postings[term] {
+				set[id] = true
+			}
+		}
+		if len(set) == 0 {
+			return nil
+		}
+		sets = append(sets, set)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+
+	result := sets[0]
+	for _, set := range sets[1:] {
+		for id := range result {
+			if !set[id] {
+				delete(result, id)
+			}
+		}
+	}
+
+	return result
+}
+
+// hasPhrases checks that each phrase appears in the document as consecutive
+// terms.
+func (idx *Index) hasPhrases(id int, phrases [][]string) bool {
+	for _, phrase := range phrases {
+		found := false
+		for _, start := range idx.postings[phrase[0]][id] {
+			if idx.phraseAt(id, phrase, start) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *Index) phraseAt(id int, phrase []string, start int) bool {
+	for offset, term := range phrase[1:] {
+		positions := idx.postings[term][id]
+		i := sort.SearchInts(positions, start+offset+1)
+		if i == len(positions) || positions[i] != start+offset+1 {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *Index) score(id int, d *document, groups [][]string) float64 {
+	n := float64(len(idx.docs))
+	avgLength := float64(idx.totalLength) / n
+	norm := k1 * (1 - b + b*float64(d.length)/avgLength)
+
+	score := 0.0
+	for _, group := range groups {
+		for _, term := range group {
+			tf := float64(len(idx.postings[term][id]))
+			if tf == 0 {
+				continue
+			}
+			df := float64(len(idx.postings[term]))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			score += idf * tf * (k1 + 1) / (tf + norm)
+		}
+	}
+
+	return score
+}
@@ -0,0 +1,94 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxQueryTerms = 32
+
+var (
+	ErrEmptyQuery = errors.New("query has no searchable terms")
+	ErrQueryTooLong = errors.New("query has too many terms")
+)
+
+// Query is a parsed search. Every term, prefix and phrase must match for a
+// document to be returned.
+type Query struct {
+	Terms []string
+	Prefixes []string
+	Phrases [][]string
+}
+
+// Tokenize splits text into normalised search terms. Text is folded to
+// compatibility form with diacritics stripped and lowercased, so "Café",
+// "cafe" and "ｃａｆｅ" all produce the same term.
+func Tokenize(text string) []string {
+	folded, _, err := transform.String(folder(), text)
+	if err != nil {
+		folded = text
+	}
+
+	return strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// folder is built per call because transformers carry state.
+func folder() transform.Transformer {
+	return transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+}
+
+// ParseQuery reads a query string. Words in double quotes form a phrase and a
+// word ending in * matches any term starting with it.
+func ParseQuery(s string) (Query, error) {
+	q := Query{}
+
+	for i, part := range strings.Split(s, `"`) {
+		// odd parts sit between quotes; an unclosed quote runs to the end
+		if i%2 == 1 {
+			phrase := Tokenize(part)
+			switch len(phrase) {
+			case 0:
+			case 1:
+				q.Terms = append(q.Terms, phrase[0])
+			default:
+				q.Phrases = append(q.Phrases, phrase)
+			}
+			continue
+		}
+
+		for _, word := range strings.Fields(part) {
+			prefix := strings.HasSuffix(word, "*")
+			tokens := Tokenize(word)
+			if len(tokens) == 0 {
+				continue
+			}
+			if prefix {
+				// only the last token of a word like "e-mai*" is a prefix
+				q.Terms = append(q.Terms, tokens[:len(tokens)-1]...)
+				q.Prefixes = append(q.Prefixes, tokens[len(tokens)-1])
+			} else {
+				q.Terms = append(q.Terms, tokens...)
+			}
+		}
+	}
+
+	n := len(q.Terms) + len(q.Prefixes)
+	for _, phrase := range q.Phrases {
+		n += len(phrase)
+	}
+	if n == 0 {
+		return Query{}, ErrEmptyQuery
+	}
+	if n > maxQueryTerms {
+		return Query{}, ErrQueryTooLong
+	}
+
+	return q, nil
+}
@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrTooManySubscribers = errors.New("too many open streams")
+
+// Event is one message on a hub. IDs start at 1 and grow by one per event,
+// so a client can say where it left off.
+type Event struct {
+	ID uint64
+	Type string
+	Payload any
+}
+
+// Hub fans events out to subscribers and keeps the most recent ones so a
+// subscriber that reconnects can catch up. Publishing never blocks: a
+// subscriber that falls a full buffer behind is dropped instead, and can
+// resume from the replay buffer once it reconnects.
+type Hub struct {
+	mutex sync.Mutex
+	subscribers map[*Subscription]bool
+	maxSubscribers int
+	bufferSize int
+
+	// replay is a ring of the last len(replay) events; the newest has ID
+	// lastID
+	replay []Event
+	lastID uint64
+}
+
+// Subscription receives a hub's events on C until it is closed. C is also
+// closed when the subscriber fell too far behind, in which case Dropped
+// reports true.
+type Subscription struct {
+	C <-chan Event
+
+	hub *Hub
+	events chan Event
+	dropped bool
+}
+
+// NewHub keeps replaySize events for resuming, allows maxSubscribers open
+// subscriptions and buffers up to bufferSize undelivered events for each.
+func NewHub(replaySize int, maxSubscribers int, bufferSize int) *Hub {
+	return &Hub{
+		subscribers: make(map[*Subscription]bool),
+		maxSubscribers: maxSubscribers,
+		bufferSize: bufferSize,
+		replay: make([]Event, 0, replaySize),
+	}
+}
+
+// Publish gives the event the next ID, records it for replay and hands it to
+// every subscriber.
+func (h *Hub) Publish(eventType string, payload any) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastID++
+	ev := Event{ID: h.lastID, Type: eventType, Payload: payload}
+
+	if cap(h.replay) > 0 {
+		if len(h.replay) < cap(h.replay) {
+			h.replay = append(h.replay, ev)
+		} else {
+			h.replay[(ev.ID-1)%uint64(cap(h.replay))] = ev
+		}
+	}
+
+	for s := range h.subscribers {
+		select {
+		case s.events <- ev:
+		default:
+			s.dropped = true
+			h.remove(s)
+		}
+	}
+}
+
+// Subscribe opens a subscription. When resume is set, the events after
+// lastID that are still held are returned to be sent first, and complete
+// reports whether that covers everything missed; when it doesn't, the
+// subscriber should refetch rather than trust the stream to fill the gap.
+func (h *Hub) Subscribe(lastID uint64, resume bool) (s *Subscription, missed []Event, complete bool, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.subscribers) >= h.maxSubscribers {
+		return nil, nil, false, ErrTooManySubscribers
+	}
+
+	complete = true
+	if resume {
+		missed, complete = h.since(lastID)
+	}
+
+	events := make(chan Event, h.bufferSize)
+	s = &Subscription{C: events, hub: h, events: events}
+	h.subscribers[s] = true
+
+	return s, missed, complete, nil
+}
+
+// since returns the held events newer than lastID, oldest first.
+func (h *Hub) since(lastID uint64) ([]Event, bool) {
+	// an ID from the future was issued before a restart
+	if lastID > h.lastID {
+		return nil, false
+	}
+
+	held := uint64(len(h.replay))
+	oldest := h.lastID - held + 1
+	if lastID+1 < oldest {
+		return h.from(oldest), false
+	}
+	return h.from(lastID + 1), true
+}
+
+func (h *Hub) from(id uint64) []Event {
+	events := []Event{}
+	for ; id <= h.lastID; id++ {
+		events = append(events, h.replay[(id-1)%uint64(cap(h.replay))])
+	}
+	return events
+}
+
+// Close ends the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+
+	s.hub.remove(s)
+}
+
+// Dropped reports whether the hub ended the subscription because it fell
+// behind.
+func (s *Subscription) Dropped() bool {
+	s.hub.mutex.Lock()
+	defer s.hub.mutex.Unlock()
+
+	return s.dropped
+}
+
+func (h *Hub) remove(s *Subscription) {
+	if h.subscribers[s] {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
@@ -0,0 +1,3 @@
+module trending
+
+go 1.22.0
@@ -0,0 +1,174 @@
+package trending
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Aggregator counts activity per key in fixed-width time buckets covering a
+// sliding span, so any window up to that span can be scored. Memory is
+// bounded by the key limit times the number of buckets.
+type Aggregator struct {
+	mutex sync.Mutex
+	counters map[string]*counter
+
+	bucketWidth time.Duration
+	buckets int64
+	maxKeys int
+
+	// Now is the clock used for scoring and expiry; tests may replace it
+	Now func() time.Time
+}
+
+// counter is a ring of buckets. newest is the absolute index, in bucket
+// widths since the Unix epoch, of the latest bucket written.
+type counter struct {
+	counts []float64
+	newest int64
+}
+
+// Trend is a key's activity within a window. Count is the raw total and
+// Score the total with older activity decayed.
+type Trend struct {
+	Key string
+	Score float64
+	Count float64
+}
+
+// NewAggregator keeps span worth of history in buckets of bucketWidth and
+// tracks at most maxKeys keys at once.
+func NewAggregator(span time.Duration, bucketWidth time.Duration, maxKeys int) *Aggregator {
+	return &Aggregator{
+		counters: make(map[string]*counter),
+		bucketWidth: bucketWidth,
+		buckets: int64((span + bucketWidth - 1) / bucketWidth),
+		maxKeys: maxKeys,
+		Now: time.Now,
+	}
+}
+
+func (a *Aggregator) bucket(t time.Time) int64 {
+	return t.UnixNano() / int64(a.bucketWidth)
+}
+
+// Record adds weight to key at time at. Activity older than the span or
+// from the future is ignored. A negative weight cancels earlier activity,
+// such as an unlike following a like.
+func (a *Aggregator) Record(key string, at time.Time, weight float64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := a.bucket(a.Now())
+	b := a.bucket(at)
+	if b > now || b <= now-a.buckets {
+		return
+	}
+
+	c, ok := a.counters[key]
+	if !ok {
+		if weight <= 0 {
+			return
+		}
+		a.makeRoom(now)
+		c = &counter{counts: make([]float64, a.buckets), newest: b}
+		a.counters[key] = c
+	}
+
+	if b > c.newest {
+		// clear the buckets skipped since the last write, at most a full turn
+		for i := c.newest + 1; i <= b && i <= c.newest+a.buckets; i++ {
+			c.counts[i%a.buckets] = 0
+		}
+		c.newest = b
+	} else if b <= c.newest-a.buckets {
+		return
+	}
+
+	c.counts[b%a.buckets] += weight
+}
+
+// Remove forgets key, for instance when the chirp it counts is deleted.
+func (a *Aggregator) Remove(key string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	delete(a.counters, key)
+}
+
+// makeRoom evicts keys until there is space for one more. Keys with no
+// activity left in the span go first, then whichever was active least
+// recently. Caller must hold the mutex.
+func (a *Aggregator) makeRoom(now int64) {
+	if len(a.counters) < a.maxKeys {
+		return
+	}
+
+	for key, c := range a.counters {
+		if c.newest <= now-a.buckets {
+			delete(a.counters, key)
+		}
+	}
+
+	for len(a.counters) >= a.maxKeys {
+		oldestKey := ""
+		oldest := int64(math.MaxInt64)
+		for key, c := range a.counters {
+			if c.newest < oldest {
+				oldestKey, oldest = key, c.newest
+			}
+		}
+		delete(a.counters, oldestKey)
+	}
+}
+
+// Top returns up to n keys, or every key if n is 0, with the highest score
+// over the last window. Activity loses half its weight every halfLife; a
+// zero halfLife disables decay. Keys whose net activity is not positive are
+// left out.
+func (a *Aggregator) Top(window time.Duration, halfLife time.Duration, n int) []Trend {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := a.bucket(a.Now())
+	span := min(int64(window/a.bucketWidth), a.buckets)
+
+	trends := []Trend{}
+	for key, c := range a.counters {
+		if c.newest <= now-a.buckets {
+			delete(a.counters, key)
+			continue
+		}
+
+		trend := Trend{Key: key}
+		for b := max(now-span+1, c.newest-a.buckets+1); b <= c.newest; b++ {
+			count := c.counts[b%a.buckets]
+			if count == 0 {
+				continue
+			}
+			trend.Count += count
+			if halfLife > 0 {
+				age := time.Duration(now-b) * a.bucketWidth
+				count *= math.Exp2(-float64(age) / float64(halfLife))
+			}
+			trend.Score += count
+		}
+
+		if trend.Count > 0 && trend.Score > 0 {
+			trends = append(trends, trend)
+		}
+	}
+
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Score != trends[j].Score {
+			return trends[i].Score > trends[j].Score
+		}
+		return trends[i].Key < trends[j].Key
+	})
+
+	if n > 0 && len(trends) > n {
+		trends = trends[:n]
+	}
+	return trends
+}
@@ -0,0 +1,153 @@
+package trending
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// base sits on a bucket boundary for every width used here.
+var base = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestAggregator keeps an hour in six ten-minute buckets and reads the
+// time from the returned clock.
+func newTestAggregator(maxKeys int) (*Aggregator, *time.Time) {
+	now := base
+	a := NewAggregator(time.Hour, 10*time.Minute, maxKeys)
+	a.Now = func() time.Time { return now }
+	return a, &now
+}
+
+func counts(trends []Trend) map[string]float64 {
+	m := make(map[string]float64)
+	for _, trend := range trends {
+		m[trend.Key] = trend.Count
+	}
+	return m
+}
+
+func TestBucketRotation(t *testing.T) {
+	a, now := newTestAggregator(10)
+
+	a.Record("go", base, 1)
+
+	*now = base.Add(50 * time.Minute)
+	a.Record("go", *now, 1)
+	if got := counts(a.Top(time.Hour, 0, 0))["go"]; got != 2 {
+		t.Fatalf("count within the span = %v, want 2", got)
+	}
+
+	// the first bucket's slot in the ring is reused and must start empty
+	*now = base.Add(time.Hour)
+	a.Record("go", *now, 1)
+	if got := counts(a.Top(time.Hour, 0, 0))["go"]; got != 2 {
+		t.Errorf("count after rotating = %v, want 2", got)
+	}
+	if got := counts(a.Top(20*time.Minute, 0, 0))["go"]; got != 2 {
+		t.Errorf("count in a 20m window = %v, want 2", got)
+	}
+	if got := counts(a.Top(10*time.Minute, 0, 0))["go"]; got != 1 {
+		t.Errorf("count in a 10m window = %v, want 1", got)
+	}
+
+	// activity older than the span is ignored
+	a.Record("go", base, 1)
+	if got := counts(a.Top(time.Hour, 0, 0))["go"]; got != 2 {
+		t.Errorf("count after recording outside the span = %v, want 2", got)
+	}
+
+	*now = base.Add(3 * time.Hour)
+	if trends := a.Top(time.Hour, 0, 0); len(trends) != 0 {
+		t.Errorf("Top after the span has passed = %v, want none", trends)
+	}
+}
+
+func TestDecay(t *testing.T) {
+	a, now := newTestAggregator(10)
+
+	a.Record("old", base, 1)
+	*now = base.Add(30 * time.Minute)
+	a.Record("new", *now, 1)
+
+	trends := a.Top(time.Hour, 30*time.Minute, 0)
+	if len(trends) != 2 || trends[0].Key != "new" || trends[1].Key != "old" {
+		t.Fatalf("Top = %v, want new then old", trends)
+	}
+	if trends[0].Score != 1 {
+		t.Errorf("score of current activity = %v, want 1", trends[0].Score)
+	}
+	if math.Abs(trends[1].Score-0.5) > 1e-9 {
+		t.Errorf("score one half-life later = %v, want 0.5", trends[1].Score)
+	}
+	if trends[1].Count != 1 {
+		t.Errorf("count one half-life later = %v, want 1", trends[1].Count)
+	}
+
+	if got := a.Top(time.Hour, 0, 0); got[0].Score != 1 || got[1].Score != 1 {
+		t.Errorf("scores without decay = %v, want 1 each", got)
+	}
+}
+
+func TestNegativeWeights(t *testing.T) {
+	a, _ := newTestAggregator(10)
+
+	a.Record("liked", base, 1)
+	a.Record("liked", base, -1)
+	a.Record("never", base, -1)
+
+	if trends := a.Top(time.Hour, 0, 0); len(trends) != 0 {
+		t.Errorf("Top = %v, want none", trends)
+	}
+	if _, ok := a.counters["never"]; ok {
+		t.Error("a negative weight started tracking a new key")
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	a, now := newTestAggregator(2)
+
+	a.Record("a", base, 1)
+	*now = base.Add(10 * time.Minute)
+	a.Record("b", *now, 1)
+	*now = base.Add(20 * time.Minute)
+	a.Record("a", *now, 1)
+
+	// b was active least recently, so it makes room for c
+	a.Record("c", *now, 1)
+	got := counts(a.Top(time.Hour, 0, 0))
+	if len(got) != 2 || got["a"] != 2 || got["c"] != 1 {
+		t.Errorf("counts = %v, want a: 2 and c: 1", got)
+	}
+
+	// keys with nothing left in the span go before active ones
+	*now = base.Add(75 * time.Minute)
+	a.Record("d", *now, 1)
+	a.Record("e", *now, 1)
+	got = counts(a.Top(time.Hour, 0, 0))
+	if len(got) != 2 || got["d"] != 1 || got["e"] != 1 {
+		t.Errorf("counts = %v, want d: 1 and e: 1", got)
+	}
+	if len(a.counters) > 2 {
+		t.Errorf("tracking %d keys, want at most 2", len(a.counters))
+	}
+}
+
+func TestRemove(t *testing.T) {
+	a, _ := newTestAggregator(10)
+
+	a.Record("kept", base, 1)
+	a.Record("gone", base, 3)
+	a.Remove("gone")
+	a.Remove("unknown")
+
+	got := counts(a.Top(time.Hour, 0, 0))
+	if len(got) != 1 || got["kept"] != 1 {
+		t.Errorf("counts = %v, want only kept", got)
+	}
+
+	// a removed key starts over
+	a.Record("gone", base, 1)
+	if got := counts(a.Top(time.Hour, 0, 0))["gone"]; got != 1 {
+		t.Errorf("count after removing and recording again = %v, want 1", got)
+	}
+}
@@ -0,0 +1,311 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Close codes from RFC 6455 section 7.4.1. Applications may use 4000-4999
+// for their own.
+const (
+	CloseNormal = 1000
+	CloseGoingAway = 1001
+	CloseProtocolError = 1002
+	CloseInvalidPayload = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig = 1009
+	CloseInternalError = 1011
+	CloseTryAgainLater = 1013
+)
+
+const (
+	opContinuation = 0x0
+	opText = 0x1
+	opBinary = 0x2
+	opClose = 0x8
+	opPing = 0x9
+	opPong = 0xA
+)
+
+// acceptGUID is mixed into the client's key to prove the server speaks
+// WebSocket.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var ErrBadHandshake = errors.New("not a valid WebSocket handshake")
+
+// CloseError is returned by ReadMessage once the peer has closed the
+// connection, or once it was closed for breaking the protocol.
+type CloseError struct {
+	Code int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// Conn is the server side of a WebSocket connection. One goroutine may read
+// while others write; writes are serialized.
+type Conn struct {
+	conn net.Conn
+	reader *bufio.Reader
+
+	// MaxMessageSize bounds an incoming message, fragments included
+	MaxMessageSize int64
+	// ReadTimeout is how long to wait for any frame, pongs included
+	ReadTimeout time.Duration
+	// WriteTimeout bounds each outgoing frame
+	WriteTimeout time.Duration
+
+	writeMutex sync.Mutex
+	closeSent bool
+}
+
+// Upgrade completes the opening handshake and takes over the connection.
+// Nothing has been written when it returns ErrBadHandshake, so the caller
+// can still respond. Origins are not checked: clients authenticate with a
+// token inside the connection, not with cookies.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, ErrBadHandshake
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn: conn,
+		reader: rw.Reader,
+		MaxMessageSize: 64 << 10,
+		ReadTimeout: time.Minute,
+		WriteTimeout: 10 * time.Second,
+	}, nil
+}
+
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text message. Pings are answered and pongs
+// swallowed along the way. Binary messages are refused, since the protocol
+// on top is JSON.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, c.fail(err)
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := &CloseError{Code: CloseNormal}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			c.Close(closeErr.Code, "")
+			return nil, closeErr
+		case opBinary:
+			return nil, c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "binary messages are not supported"})
+		case opText:
+			if started {
+				return nil, c.fail(&CloseError{Code: CloseProtocolError, Reason: "expected a continuation frame"})
+			}
+			started = true
+		case opContinuation:
+			if !started {
+				return nil, c.fail(&CloseError{Code: CloseProtocolError, Reason: "unexpected continuation frame"})
+			}
+		default:
+			return nil, c.fail(&CloseError{Code: CloseProtocolError, Reason: "unknown opcode"})
+		}
+
+		if int64(len(message)+len(payload)) > c.MaxMessageSize {
+			return nil, c.fail(&CloseError{Code: CloseMessageTooBig, Reason: "message too big"})
+		}
+		message = append(message, payload...)
+
+		if fin {
+			if !utf8.Valid(message) {
+				return nil, c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "text must be UTF-8"})
+			}
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads one frame and unmasks its payload.
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &CloseError{Code: CloseProtocolError, Reason: "no extensions were negotiated"}
+	}
+	// clients must mask every frame
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &CloseError{Code: CloseProtocolError, Reason: "frames from clients must be masked"}
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+
+	if opcode >= opClose && (!fin || length > 125) {
+		return false, 0, nil, &CloseError{Code: CloseProtocolError, Reason: "control frames must be short and unfragmented"}
+	}
+	if length > uint64(c.MaxMessageSize) {
+		return false, 0, nil, &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// fail closes the connection after a protocol violation, telling the peer
+// why when it can.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.Close(closeErr.Code, closeErr.Reason)
+	} else {
+		c.conn.Close()
+	}
+	return err
+}
+
+// WriteText sends data as a single text message.
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// Ping asks the peer to prove it is still there. Its pong resets the read
+// timeout like any other frame.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close frame with code and reason, then drops the
+// connection. Only the first call sends anything.
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	err := c.writeFrame(opClose, payload)
+	c.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	// servers never mask
+	frame := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
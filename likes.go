@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+)
+
+func (cfg *apiConfig) likeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setLikeHandler(w, r, true)
+}
+
+func (cfg *apiConfig) unlikeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setLikeHandler(w, r, false)
+}
+
+func (cfg *apiConfig) setLikeHandler(w http.ResponseWriter, r *http.Request, liked bool) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	var chirp database.Chirp
+	if liked {
+		chirp, err = cfg.db.LikeChirp(userID, chirpID)
+	} else {
+		chirp, err = cfg.db.UnlikeChirp(userID, chirpID)
+	}
+	if errors.Is(err, database.ErrChirpID) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(401)
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating like: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(chirp)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) getUserLikesHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	chirps, err := cfg.db.GetLikedChirps(userID, viewerID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting liked chirps: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := cfg.db.SetViewerState(viewerID, chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(chirps)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
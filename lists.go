@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+)
+
+type listParameters struct {
+	Name *string `json:"name"`
+	Description *string `json:"description"`
+	Private *bool `json:"private"`
+}
+
+// respondWithListError maps the errors shared by the list endpoints to a
+// response, and reports whether it wrote one.
+func respondWithListError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, database.ErrListNotFound):
+		w.WriteHeader(404)
+	case errors.Is(err, database.ErrAuthorization):
+		w.WriteHeader(403)
+	case errors.Is(err, database.ErrBlocked):
+		respondWithError(w, 403, err.Error())
+	case errors.Is(err, database.ErrInvalidList), errors.Is(err, database.ErrOwnList):
+		respondWithError(w, 400, err.Error())
+	case errors.Is(err, database.ErrTooManyLists), errors.Is(err, database.ErrListFull):
+		respondWithError(w, 409, err.Error())
+	default:
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) createListHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	params := listParameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid JSON body")
+		return
+	}
+
+	name, description, private := "", "", false
+	if params.Name != nil {
+		name = *params.Name
+	}
+	if params.Description != nil {
+		description = *params.Description
+	}
+	if params.Private != nil {
+		private = *params.Private
+	}
+
+	list, err := cfg.db.CreateList(userID, name, description, private)
+	if respondWithListError(w, err) {
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(401)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error creating list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(list)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(201)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) updateListHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("listID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	params := listParameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid JSON body")
+		return
+	}
+
+	list, err := cfg.db.UpdateList(userID, listID, database.ListUpdate{
+		Name: params.Name,
+		Description: params.Description,
+		Private: params.Private,
+	})
+	if respondWithListError(w, err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(list)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) deleteListHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("listID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	err = cfg.db.DeleteList(userID, listID)
+	if respondWithListError(w, err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error deleting list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) getListHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("listID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	list, err := cfg.db.GetList(listID, viewerID)
+	if respondWithListError(w, err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting list: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(list)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+// getOwnListsHandler returns the caller's lists, private ones included.
+func (cfg *apiConfig) getOwnListsHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.getListsHandler(w, r, func(userID int) ([]database.ListSummary, error) {
+		return cfg.db.GetOwnedLists(userID, userID)
+	})
+}
+
+func (cfg *apiConfig) getSubscribedListsHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.getListsHandler(w, r, cfg.db.GetSubscribedLists)
+}
+
+func (cfg *apiConfig) getListsHandler(w http.ResponseWriter, r *http.Request, lists func(int) ([]database.ListSummary, error)) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	found, err := lists(userID)
+	if err != nil {
+		fmt.Printf("Error getting lists: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(found)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+// getUserListsHandler returns the public lists a user has made.
+func (cfg *apiConfig) getUserListsHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	ownerID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	lists, err := cfg.db.GetOwnedLists(ownerID, viewerID)
+	if err != nil {
+		fmt.Printf("Error getting lists: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(lists)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) getListMembersHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("listID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	members, err := cfg.db.GetListMembers(listID, viewerID)
+	if respondWithListError(w, err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting list members: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(members)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) addListMemberHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setListMemberHandler(w, r, true)
+}
+
+func (cfg *apiConfig) removeListMemberHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setListMemberHandler(w, r, false)
+}
+
+func (cfg *apiConfig) setListMemberHandler(w http.ResponseWriter, r *http.Request, add bool) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("listID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	memberID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	if add {
+		err = cfg.db.AddListMember(userID, listID, memberID)
+	} else {
+		err = cfg.db.RemoveListMember(userID, listID, memberID)
+	}
+	if respondWithListError(w, err) {
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating list members: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) subscribeListHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setListSubscriptionHandler(w, r, true)
+}
+
+func (cfg *apiConfig) unsubscribeListHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.setListSubscriptionHandler(w, r, false)
+}
+
+func (cfg *apiConfig) setListSubscriptionHandler(w http.ResponseWriter, r *http.Request, subscribe bool) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("listID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	err = cfg.db.SubscribeList(userID, listID, subscribe)
+	if respondWithListError(w, err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating list subscription: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) getListTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	listID, err := strconv.Atoi(r.PathValue("listID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	before, limit, err := parsePageParams(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	// fetch one extra to know whether another page exists
+	chirps, err := cfg.db.GetListTimeline(listID, viewerID, before, limit+1)
+	if respondWithListError(w, err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting list timeline: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	page := newChirpPage(chirps, limit)
+
+	if err := cfg.db.SetViewerState(viewerID, page.Chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(page)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"internal/audit"
 	"internal/auth"
 	"internal/database"
+	"internal/media"
+	"internal/password"
+	"internal/search"
+	"internal/stream"
+	"internal/trending"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 )	
 
 type handler struct {
@@ -24,12 +32,70 @@ type apiConfig struct {
 	fileServerHits int
 	db *database.DB
 	jwtsecret string
+	accountThrottle *auth.LoginThrottle
+	ipThrottle *auth.LoginThrottle
+	dummyHash string
+	hasher *password.Hasher
+	passwordPolicy *password.Policy
+	deletionGracePeriod time.Duration
+	chirpDeletionPolicy string
+	chirpEditWindow time.Duration
+	auditLog *audit.Log
+	auditRetention time.Duration
+	searchIndex *search.Index
+	trendingTags *trending.Aggregator
+	trendingChirps *trending.Aggregator
+	mediaStore *media.Store
+	mediaLimits media.Limits
+	mediaMaxBytes int64
+	mediaQuota int64
+	mediaOrphanAge time.Duration
+	chirpStream *stream.Hub
+	notificationStream *stream.Hub
 }
 
+type contextKey string
+
+const identityContextKey contextKey = "identity"
+
 type errorReturnVal struct {
 	Error string `json:"error"`
 }
 
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	msg, err := json.Marshal(errorReturnVal{Error: message})
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(code)
+	w.Write(msg)
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envString(name string, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func outputHTML(w http.ResponseWriter, filename string, data interface{}) {
 	t, err := template.ParseFiles(filename)
 	if err != nil {
@@ -56,6 +122,57 @@ func (cfg *apiConfig) middlewareMetrics(next http.Handler) http.Handler {
 		})
 }
 
+// middlewareRequireRole rejects requests whose access token does not carry
+// at least the given role, and makes the caller's identity available to next.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			bearerToken, err := auth.ParseBearerToken(r.Header.Get("Authorization"))
+			if err != nil {
+				fmt.Printf("Error parsing bearer token: %s", err)
+				w.WriteHeader(401)
+				return
+			}
+
+			identity, err := auth.ParseIdentityFromJWT(bearerToken)
+			if err != nil {
+				fmt.Printf("Error validating jwt token: %s", err)
+				w.WriteHeader(401)
+				return
+			}
+
+			if !auth.HasRole(identity.Role, role) {
+				respondWithError(w, 403, auth.ErrForbidden.Error())
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), identityContextKey, identity)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+}
+
+func identityFromContext(ctx context.Context) (auth.Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey).(auth.Identity)
+	return identity, ok
+}
+
+// viewerIDFromRequest identifies the caller on endpoints where signing in is
+// optional. Anonymous requests get 0; a token that fails validation is an
+// error rather than being silently treated as anonymous.
+func viewerIDFromRequest(r *http.Request) (int, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return 0, nil
+	}
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		return 0, err
+	}
+
+	return auth.ParseUserIDFromJWT(bearerToken)
+}
+
 func (cfg *apiConfig) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
@@ -68,6 +185,13 @@ func (cfg *apiConfig) resetMetrics(next http.Handler) http.Handler {
 		func(w http.ResponseWriter, r *http.Request) {
 			next.ServeHTTP(w, r)
 			cfg.fileServerHits = 0
+
+			identity, _ := identityFromContext(r.Context())
+			cfg.recordAudit(r, audit.Entry{
+				Action: audit.ActionAdminMetricsReset,
+				ActorID: identity.UserID,
+				Outcome: audit.OutcomeSuccess,
+			})
 	})
 }
 
@@ -104,7 +228,7 @@ func replaceProfane(s string) string {
 			}
 		}
 		if i < len(s) {
-			ns = fmt.Sprintf("%s%s", ns, string(s[i]))
+			ns = fmt.Sprintf("%s%s", ns, s[i:i+1])
 		}
 	}
 	return ns
@@ -127,8 +251,13 @@ func (cfg *apiConfig) addChirpHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	type parameters struct {
+		database.Chirp
+		Poll *pollParameters `json:"poll"`
+	}
+
 	decoder := json.NewDecoder(r.Body)
-	params := database.Chirp{}
+	params := parameters{}
 
 	if err := decoder.Decode(&params); err != nil {
 		errorBody := errorReturnVal{
@@ -147,15 +276,40 @@ func (cfg *apiConfig) addChirpHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write(msg)
 		return
 	}
-	valid := validateChirpLength(params.Body)
-	if !valid {
-		err := errors.New("chirp is too long")
+	body, err := cleanChirpBody(params.Body)
+	if err != nil {
 		fmt.Printf("Invalid chirp body: %s", err)
 		w.WriteHeader(400)
 		return
 	}
 
-	chirp, err := cfg.db.CreateChirp(replaceProfane(params.Body), ID)
+	opts := database.ChirpOptions{
+		InReplyTo: params.InReplyTo,
+		QuoteOf: params.QuoteOf,
+		MediaIDs: params.MediaIDs,
+		Visibility: params.Visibility,
+	}
+	if params.Poll != nil {
+		opts.Poll, err = params.Poll.options(time.Now())
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+	}
+
+	chirp, err := cfg.db.CreateChirp(body, ID, opts)
+	if errors.Is(err, database.ErrReplyTarget) || errors.Is(err, database.ErrQuoteTarget) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrMediaUnavailable) || errors.Is(err, database.ErrTooManyMedia) || errors.Is(err, database.ErrInvalidPoll) || errors.Is(err, database.ErrInvalidVisibility) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
 	if err != nil {
 		fmt.Printf("Error creating chirp: %s", err)
 		w.WriteHeader(500)
@@ -173,33 +327,58 @@ func (cfg *apiConfig) addChirpHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	authorIDString := r.URL.Query().Get("author_id")
-	sortParam := r.URL.Query().Get("sort")
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
 
-	authorID, _ := strconv.Atoi(authorIDString)
+	filter, details := parseChirpFilter(r)
+	if len(details) > 0 {
+		respondWithParamErrors(w, details)
+		return
+	}
+	filter.ViewerID = viewerID
 
-	chirps, err := cfg.db.GetChirps(authorID, sortParam)
+	list, err := cfg.db.ListChirps(filter)
 	if err != nil {
 		fmt.Printf("Error getting chirps: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	msg, err := json.Marshal(chirps)
+	if err := cfg.db.SetViewerState(viewerID, list.Chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(list.Chirps)
 	if err != nil {
 		fmt.Printf("Error marshalling JSON: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	if links := chirpListLinks(r, list); links != "" {
+		w.Header().Set("Link", links)
+	}
 	w.WriteHeader(200)
 	w.Write(msg)
 }
 
 func (cfg *apiConfig) getChirpByIDHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
 	idString := r.PathValue("chirpID")
 
-	chirp, err := cfg.db.GetChirpByID(idString)
+	chirp, err := cfg.db.GetChirpByID(idString, viewerID)
 	if err != nil {
 		if err == database.ErrChirpID {
 			w.WriteHeader(404)
@@ -209,8 +388,15 @@ func (cfg *apiConfig) getChirpByIDHandler(w http.ResponseWriter, r *http.Request
 		w.WriteHeader(500)
 		return
 	}
+
+	chirps := []database.Chirp{chirp}
+	if err := cfg.db.SetViewerState(viewerID, chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
 	
-	msg, err := json.Marshal(chirp)
+	msg, err := json.Marshal(chirps[0])
 	if err != nil {
 		fmt.Printf("Error marshalling JSON: %s", err)
 		w.WriteHeader(500)
@@ -225,6 +411,17 @@ func validateChirpLength(c string) (bool) {
 	return len(c) <= 140 
 }
 
+var errChirpTooLong = errors.New("chirp is too long")
+
+// cleanChirpBody runs a chirp body through length validation and the
+// profanity filter. Every path that stores a body goes through here.
+func cleanChirpBody(body string) (string, error) {
+	if !validateChirpLength(body) {
+		return "", errChirpTooLong
+	}
+	return replaceProfane(body), nil
+}
+
 func (cfg *apiConfig) addUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	decoder := json.NewDecoder(r.Body)
@@ -248,19 +445,33 @@ func (cfg *apiConfig) addUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(params.Password), 10)
+	if err := cfg.passwordPolicy.Validate(params.Password); err != nil {
+		respondWithError(w, 422, err.Error())
+		return
+	}
+
+	hashed, err := cfg.hasher.Hash(params.Password)
 	if err != nil {
-		fmt.Println("Error generating password hash")
+		fmt.Printf("Error generating password hash: %s", err)
 		w.WriteHeader(500)
+		return
 	}
 
-	user, err := cfg.db.CreateUser(params.Email, string(hashed))
-	
+	user, err := cfg.db.CreateUser(params.Email, hashed, database.RoleUser, params.Handle)
+	if errors.Is(err, database.ErrInvalidHandle) || errors.Is(err, database.ErrReservedHandle) {
+		respondWithError(w, 422, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrHandleTaken) || errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, 409, err.Error())
+		return
+	}
 	if err != nil {
 		fmt.Printf("Error creating user: %s", err)
 		w.WriteHeader(500)
 		return
 	}
+	user.Password = ""
 
 	msg, err := json.Marshal(user)
 	if err != nil {
@@ -299,19 +510,66 @@ func (cfg *apiConfig) verifyUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := cfg.db.GetUserByEmail(params.Email)
-	if err != nil {
-		fmt.Printf("User %s not found", params.Email)
-		w.WriteHeader(400)
+	accountKey := "account:" + strings.ToLower(params.Email)
+	ip := clientIP(r)
+	ipKey := "ip:" + ip
+
+	wait := cfg.accountThrottle.Blocked(accountKey)
+	if ipWait := cfg.ipThrottle.Blocked(ipKey); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionLogin,
+			Subject: params.Email,
+			Outcome: audit.OutcomeDenied,
+			Detail: "throttled",
+		})
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		respondWithError(w, 429, "Too many login attempts, try again later")
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password))
-	if err != nil {
-		w.WriteHeader(401)
+	// Compare against a dummy hash when the email is unknown so both failure
+	// paths take the same time and return the same response.
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	hash := cfg.dummyHash
+	if err == nil {
+		hash = user.Password
+	}
+	needsRehash, err := cfg.hasher.Verify(params.Password, hash)
+	if err != nil || user.ID == 0 {
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionLogin,
+			TargetID: user.ID,
+			Subject: params.Email,
+			Outcome: audit.OutcomeFailure,
+		})
+		cfg.recordLoginFailure(r, cfg.accountThrottle, "account", accountKey, params.Email, ip)
+		cfg.recordLoginFailure(r, cfg.ipThrottle, "ip", ipKey, ip, ip)
+		respondWithError(w, 401, "Incorrect email or password")
 		return
 	}
 
+	cfg.accountThrottle.RecordSuccess(accountKey)
+	cfg.ipThrottle.RecordSuccess(ipKey)
+
+	if user.DeletionScheduledFor != nil {
+		if err := cfg.db.CancelUserDeletion(user.ID); err != nil {
+			fmt.Printf("Error cancelling deletion for user %d: %s", user.ID, err)
+		}
+	}
+
+	if needsRehash {
+		rehashed, err := cfg.hasher.Hash(params.Password)
+		if err == nil {
+			_, err = cfg.db.UpdateUser(user.ID, database.User{Password: rehashed})
+		}
+		if err != nil {
+			fmt.Printf("Error rehashing password for user %d: %s", user.ID, err)
+		}
+	}
+
 	refreshToken, err := cfg.db.GenerateRefreshToken(user.ID)
 	if err != nil {
 		fmt.Printf("Error generating refresh token: %s", err)
@@ -326,12 +584,24 @@ func (cfg *apiConfig) verifyUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	cfg.recordAudit(r, audit.Entry{
+		Action: audit.ActionLogin,
+		ActorID: user.ID,
+		Subject: user.Email,
+		Outcome: audit.OutcomeSuccess,
+	})
+
 	userNoPass := database.User{
 		Email: user.Email,
 		ID: user.ID,
 		Token: jwt,
 		RefreshToken: refreshToken,
 		IsChirpyRed: user.IsChirpyRed,
+		Role: user.Role,
+		Handle: user.Handle,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		AvatarMediaID: user.AvatarMediaID,
 	}
 
 	msg, err := json.Marshal(userNoPass)
@@ -344,6 +614,114 @@ func (cfg *apiConfig) verifyUserHandler(w http.ResponseWriter, r *http.Request)
 	w.Write(msg)
 }
 
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, throttle *auth.LoginThrottle, scope string, key string, subject string, ip string) {
+	result := throttle.RecordFailure(key)
+	if !result.Locked {
+		return
+	}
+
+	cfg.recordAudit(r, audit.Entry{
+		Action: audit.ActionLoginLockout,
+		Subject: subject,
+		Outcome: audit.OutcomeDenied,
+		Detail: fmt.Sprintf("%s locked until %s", scope, result.BlockedUntil.Format(time.RFC3339)),
+	})
+
+	fmt.Printf("Login locked out for %s %s until %s\n", scope, subject, result.BlockedUntil.Format(time.RFC3339))
+	_, err := cfg.db.RecordLockoutEvent(database.LockoutEvent{
+		Scope: scope,
+		Subject: subject,
+		IP: ip,
+		Failures: throttle.LockoutThreshold,
+		LockedUntil: result.BlockedUntil,
+	})
+	if err != nil {
+		fmt.Printf("Error recording lockout event: %s", err)
+	}
+}
+
+func (cfg *apiConfig) getLockoutEventsHandler(w http.ResponseWriter, r *http.Request) {
+	events, err := cfg.db.GetLockoutEvents()
+	if err != nil {
+		fmt.Printf("Error getting lockout events: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(events)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid user id")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+
+	type roleParams struct {
+		Role string `json:"role"`
+	}
+	params := roleParams{}
+
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid request body")
+		return
+	}
+
+	if !auth.ValidRole(params.Role) {
+		respondWithError(w, 422, "Role must be one of user, moderator or admin")
+		return
+	}
+
+	identity, _ := identityFromContext(r.Context())
+
+	user, err := cfg.db.UpdateUserRole(userID, params.Role)
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating user role: %s", err)
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionAdminRoleChange,
+			ActorID: identity.UserID,
+			TargetID: userID,
+			Outcome: audit.OutcomeFailure,
+			Detail: params.Role,
+		})
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.recordAudit(r, audit.Entry{
+		Action: audit.ActionAdminRoleChange,
+		ActorID: identity.UserID,
+		TargetID: userID,
+		Outcome: audit.OutcomeSuccess,
+		Detail: params.Role,
+	})
+
+	msg, err := json.Marshal(database.User{ID: user.ID, Email: user.Email, IsChirpyRed: user.IsChirpyRed, Role: user.Role})
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
 func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 	header := r.Header.Get("Authorization")
 
@@ -361,8 +739,17 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// profile fields are pointers so that leaving one out keeps it as is
+	type parameters struct {
+		database.User
+		Handle *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio *string `json:"bio"`
+		AvatarMediaID *int `json:"avatar_media_id"`
+	}
+
 	decoder := json.NewDecoder(r.Body)
-	params := database.User{}
+	params := parameters{}
 
 	if err := decoder.Decode(&params); err != nil {
 		errorBody := errorReturnVal{
@@ -382,24 +769,84 @@ func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	hashed, err := bcrypt.GenerateFromPassword([]byte(params.Password), 10)
+	if params.Password != "" {
+		if err := cfg.passwordPolicy.Validate(params.Password); err != nil {
+			respondWithError(w, 422, err.Error())
+			return
+		}
+
+		hashed, err := cfg.hasher.Hash(params.Password)
+		if err != nil {
+			fmt.Printf("Error generating password hash: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		params.Password = hashed
+	}
+
+	previous, err := cfg.db.GetUserByID(ID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(404)
+		return
+	}
 	if err != nil {
-		fmt.Println("Error generating password hash")
+		fmt.Printf("Error getting user: %s", err)
 		w.WriteHeader(500)
+		return
 	}
 
-	params.Password = string(hashed)
-
-	user, err := cfg.db.UpdateUser(ID, params)
+	// the profile and the email or password are changed together, so a
+	// rejected handle or a taken email leaves the account untouched
+	update := database.ProfileUpdate{
+		Handle: params.Handle,
+		DisplayName: params.DisplayName,
+		Bio: params.Bio,
+		AvatarMediaID: params.AvatarMediaID,
+	}
+	user, err := cfg.db.UpdateAccount(ID, params.User, update)
+	if errors.Is(err, database.ErrInvalidHandle) || errors.Is(err, database.ErrReservedHandle) || errors.Is(err, database.ErrInvalidProfile) {
+		respondWithError(w, 422, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrHandleTaken) || errors.Is(err, database.ErrEmailTaken) {
+		respondWithError(w, 409, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrMediaUnavailable) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
 	if err != nil {
 		fmt.Printf("Error updating user: %s", err)
 		w.WriteHeader(500)
+		return
+	}
+
+	if params.Password != "" {
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionPasswordChange,
+			ActorID: ID,
+			Outcome: audit.OutcomeSuccess,
+		})
+	}
+	if user.Email != previous.Email {
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionEmailChange,
+			ActorID: ID,
+			Outcome: audit.OutcomeSuccess,
+			Detail: fmt.Sprintf("%s -> %s", previous.Email, user.Email),
+		})
 	}
 
 	userNoPass := database.User{
 		Email: user.Email,
 		ID: user.ID,
 		IsChirpyRed: user.IsChirpyRed,
+		Role: user.Role,
+		Handle: user.Handle,
+		DisplayName: user.DisplayName,
+		Bio: user.Bio,
+		AvatarMediaID: user.AvatarMediaID,
 	}
 
 	msg, err := json.Marshal(userNoPass)
@@ -425,10 +872,22 @@ func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
 	newJWT, err := cfg.db.GenerateAccessToken(bearerToken)
 	if err != nil {
 		fmt.Printf("Error generating access token: %s", err)
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionTokenRefresh,
+			Outcome: audit.OutcomeFailure,
+			Detail: err.Error(),
+		})
 		w.WriteHeader(401)
 		return
 	}
 
+	actorID, _ := auth.ParseUserIDFromJWT(newJWT)
+	cfg.recordAudit(r, audit.Entry{
+		Action: audit.ActionTokenRefresh,
+		ActorID: actorID,
+		Outcome: audit.OutcomeSuccess,
+	})
+
 	type AccessTokenResponse struct {
 		Token string `json:"token"`
 	}
@@ -454,13 +913,27 @@ func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a token that is already gone still revokes cleanly, just without an actor
+	refreshToken, _ := cfg.db.GetRefreshToken(bearerToken)
+
 	err = cfg.db.RevokeRefreshToken(bearerToken)
 	if err != nil {
 		fmt.Printf("Error revoking access token: %s", err)
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionTokenRevoke,
+			ActorID: refreshToken.UserID,
+			Outcome: audit.OutcomeFailure,
+		})
 		w.WriteHeader(500)
 		return
 	}
 
+	cfg.recordAudit(r, audit.Entry{
+		Action: audit.ActionTokenRevoke,
+		ActorID: refreshToken.UserID,
+		Outcome: audit.OutcomeSuccess,
+	})
+
 	w.WriteHeader(204)
 }
 
@@ -490,6 +963,10 @@ func (cfg *apiConfig) deleteChirpHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	err = cfg.db.DeleteChirpFromDB(userID, chirpID)
+	if errors.Is(err, database.ErrChirpID) {
+		w.WriteHeader(404)
+		return
+	}
 	if err != nil {
 		fmt.Printf("Error deleting chirp: %s", err)
 		w.WriteHeader(403)
@@ -503,6 +980,12 @@ func (cfg *apiConfig) upgradeUserHandler(w http.ResponseWriter, r *http.Request)
 	header := r.Header.Get("Authorization")
 	apikey := strings.Replace(header, "ApiKey ", "", 1)
 	if apikey != os.Getenv("POLKA_API_KEY") {
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionChirpyRedUpgrade,
+			Subject: "polka",
+			Outcome: audit.OutcomeDenied,
+			Detail: "invalid api key",
+		})
 		w.WriteHeader(401)
 		return
 	}
@@ -542,6 +1025,15 @@ func (cfg *apiConfig) upgradeUserHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	err := cfg.db.UpdateChirpyRedStatus(params.Data.UserID, true)
+	if err != nil {
+		cfg.recordAudit(r, audit.Entry{
+			Action: audit.ActionChirpyRedUpgrade,
+			TargetID: params.Data.UserID,
+			Subject: "polka",
+			Outcome: audit.OutcomeFailure,
+			Detail: err.Error(),
+		})
+	}
 	if errors.Is(err, database.ErrUserNotFound) {
 		w.WriteHeader(404)
 		return
@@ -552,6 +1044,13 @@ func (cfg *apiConfig) upgradeUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	cfg.recordAudit(r, audit.Entry{
+		Action: audit.ActionChirpyRedUpgrade,
+		TargetID: params.Data.UserID,
+		Subject: "polka",
+		Outcome: audit.OutcomeSuccess,
+	})
+
 	type SuccessReturnValue struct {
 		Body string `json:"body"`
 	}
@@ -580,35 +1079,184 @@ func main() {
 		Handler: mux,
 	}
 
-	db, err := database.NewDB(".")
+	// a one-off command must never wipe the database it works on
+	reset := envString("DB_RESET_ON_START", "false") == "true" && len(os.Args) == 1
+	db, err := database.NewDB(".", reset)
 	if err != nil {
-		fmt.Println("db error")
+		fmt.Printf("Error opening database: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(db, os.Args[1:]))
 	}
 	
+	hashParams := password.DefaultParams()
+	hashParams.Algorithm = envString("PASSWORD_HASH_ALGORITHM", hashParams.Algorithm)
+	hashParams.Memory = uint32(envInt("ARGON2_MEMORY_KIB", int(hashParams.Memory)))
+	hashParams.Iterations = uint32(envInt("ARGON2_ITERATIONS", int(hashParams.Iterations)))
+	hashParams.Parallelism = uint8(envInt("ARGON2_PARALLELISM", int(hashParams.Parallelism)))
+	hashParams.BcryptCost = envInt("BCRYPT_COST", hashParams.BcryptCost)
+
+	hasher, err := password.NewHasher(hashParams)
+	if err != nil {
+		fmt.Printf("Invalid password hash settings: %s\n", err)
+		os.Exit(1)
+	}
+
+	passwordPolicy, err := password.LoadPolicy(envInt("PASSWORD_MIN_LENGTH", 8), envString("PASSWORD_BLOCKLIST_FILE", "common-passwords.txt"))
+	if err != nil {
+		fmt.Printf("Error loading password policy: %s\n", err)
+		os.Exit(1)
+	}
+
+	dummyHash, err := hasher.Hash("chirpy-dummy-password")
+	if err != nil {
+		fmt.Println("Error generating dummy password hash")
+	}
+
 	h := handler{body:"OK"}
 	apiCfg := apiConfig{
 		fileServerHits: 0, 
 		db: db,
 		jwtsecret: os.Getenv("JWT_SECRET_KEY"),
+		accountThrottle: auth.NewLoginThrottle(3, 10, 15*time.Minute),
+		ipThrottle: auth.NewLoginThrottle(10, 50, 15*time.Minute),
+		dummyHash: dummyHash,
+		hasher: hasher,
+		passwordPolicy: passwordPolicy,
+		deletionGracePeriod: time.Duration(envInt("ACCOUNT_DELETION_GRACE_HOURS", 7*24)) * time.Hour,
+		chirpEditWindow: time.Duration(envInt("CHIRP_EDIT_WINDOW_MINUTES", 15)) * time.Minute,
+		chirpDeletionPolicy: envString("ACCOUNT_DELETION_CHIRP_POLICY", database.ChirpPolicyAnonymize),
 	}
+
+	auditLog, err := audit.NewLog(envString("AUDIT_LOG_PATH", "audit.log"))
+	if err != nil {
+		fmt.Printf("Error opening audit log: %s\n", err)
+		os.Exit(1)
+	}
+	apiCfg.auditLog = auditLog
+	apiCfg.auditRetention = time.Duration(envInt("AUDIT_RETENTION_DAYS", 90)) * 24 * time.Hour
+
+	if apiCfg.chirpDeletionPolicy != database.ChirpPolicyDelete && apiCfg.chirpDeletionPolicy != database.ChirpPolicyAnonymize {
+		fmt.Printf("ACCOUNT_DELETION_CHIRP_POLICY must be %q or %q\n", database.ChirpPolicyDelete, database.ChirpPolicyAnonymize)
+		os.Exit(1)
+	}
+
+	if err := apiCfg.buildSearchIndex(); err != nil {
+		fmt.Printf("Error building search index: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := apiCfg.buildTrending(envInt("TRENDING_MAX_KEYS", 5000)); err != nil {
+		fmt.Printf("Error building trending counts: %s\n", err)
+		os.Exit(1)
+	}
+
+	apiCfg.buildStream(envInt("STREAM_REPLAY_SIZE", 1000), envInt("STREAM_MAX_CONNECTIONS", 200), envInt("STREAM_BUFFER_SIZE", 64))
+
+	mediaStore, err := media.NewStore(envString("MEDIA_DIR", "media"))
+	if err != nil {
+		fmt.Printf("Error opening media store: %s\n", err)
+		os.Exit(1)
+	}
+	apiCfg.mediaStore = mediaStore
+	apiCfg.mediaLimits = media.Limits{
+		MaxPixels: envInt("MEDIA_MAX_PIXELS", 40_000_000),
+		MaxFrames: envInt("MEDIA_MAX_GIF_FRAMES", 500),
+		ThumbnailSize: envInt("MEDIA_THUMBNAIL_SIZE", 320),
+	}
+	apiCfg.mediaMaxBytes = int64(envInt("MEDIA_MAX_UPLOAD_MB", 5)) << 20
+	apiCfg.mediaQuota = int64(envInt("MEDIA_QUOTA_MB", 100)) << 20
+	apiCfg.mediaOrphanAge = time.Duration(envInt("MEDIA_ORPHAN_HOURS", 24)) * time.Hour
+
 	fs := http.FileServer(http.Dir("."))
-	prefixHandler := http.StripPrefix("/app", fs)
+	prefixHandler := http.StripPrefix("/app", middlewareHideFiles(fs, "database.json", auditLog.Path()))
 
 
-	mux.Handle("GET /admin/metrics", http.StripPrefix("/admin/", &apiCfg))
+	mux.Handle("GET /admin/metrics", apiCfg.middlewareRequireRole(database.RoleAdmin, http.StripPrefix("/admin/", &apiCfg)))
+	mux.Handle("GET /admin/lockouts", apiCfg.middlewareRequireRole(database.RoleAdmin, http.HandlerFunc(apiCfg.getLockoutEventsHandler)))
+	mux.Handle("GET /admin/audit", apiCfg.middlewareRequireRole(database.RoleAdmin, http.HandlerFunc(apiCfg.getAuditLogHandler)))
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareRequireRole(database.RoleAdmin, http.HandlerFunc(apiCfg.updateUserRoleHandler)))
 	mux.Handle("GET /api/healthz", h)
-	mux.Handle("/api/reset", apiCfg.resetMetrics(h))
+	mux.Handle("/api/reset", apiCfg.middlewareRequireRole(database.RoleAdmin, apiCfg.resetMetrics(h)))
 	mux.Handle("/app/*", apiCfg.middlewareMetrics(prefixHandler))
 	mux.HandleFunc("POST /api/chirps", apiCfg.addChirpHandler)
 	mux.HandleFunc("GET /api/chirps", apiCfg.getChirpsHandler)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.getChirpByIDHandler)
+	mux.HandleFunc("PATCH /api/chirps/{chirpID}", apiCfg.editChirpHandler)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/history", apiCfg.getChirpHistoryHandler)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/thread", apiCfg.getThreadHandler)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/poll/votes", apiCfg.votePollHandler)
+	mux.HandleFunc("PUT /api/chirps/{chirpID}/like", apiCfg.likeChirpHandler)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/like", apiCfg.unlikeChirpHandler)
+	mux.HandleFunc("POST /api/chirps/{chirpID}/rechirp", apiCfg.rechirpHandler)
+	mux.HandleFunc("DELETE /api/chirps/{chirpID}/rechirp", apiCfg.unrechirpHandler)
+	mux.HandleFunc("PUT /api/users/{userID}/follow", apiCfg.followHandler)
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", apiCfg.unfollowHandler)
+	mux.HandleFunc("GET /api/users/{userID}/followers", apiCfg.getFollowersHandler)
+	mux.HandleFunc("GET /api/users/{userID}/following", apiCfg.getFollowingHandler)
+	mux.HandleFunc("GET /api/timeline", apiCfg.getTimelineHandler)
+	mux.HandleFunc("GET /api/hashtags/{tag}/chirps", apiCfg.getHashtagChirpsHandler)
+	mux.HandleFunc("GET /api/search", apiCfg.searchHandler)
+	mux.HandleFunc("GET /api/trending", apiCfg.getTrendingHandler)
+	mux.HandleFunc("POST /api/drafts", apiCfg.createDraftHandler)
+	mux.HandleFunc("GET /api/drafts", apiCfg.getDraftsHandler)
+	mux.HandleFunc("GET /api/drafts/{draftID}", apiCfg.getDraftHandler)
+	mux.HandleFunc("PUT /api/drafts/{draftID}", apiCfg.updateDraftHandler)
+	mux.HandleFunc("DELETE /api/drafts/{draftID}", apiCfg.deleteDraftHandler)
+	mux.HandleFunc("POST /api/drafts/{draftID}/publish", apiCfg.publishDraftHandler)
+	mux.HandleFunc("POST /api/conversations", apiCfg.createConversationHandler)
+	mux.HandleFunc("GET /api/conversations", apiCfg.getConversationsHandler)
+	mux.HandleFunc("GET /api/conversations/{conversationID}/messages", apiCfg.getMessagesHandler)
+	mux.HandleFunc("POST /api/conversations/{conversationID}/messages", apiCfg.sendMessageHandler)
+	mux.HandleFunc("POST /api/conversations/{conversationID}/read", apiCfg.markConversationReadHandler)
+	mux.HandleFunc("GET /api/stream", apiCfg.streamHandler)
+	mux.HandleFunc("GET /api/ws", apiCfg.websocketHandler)
+	mux.HandleFunc("POST /api/lists", apiCfg.createListHandler)
+	mux.HandleFunc("GET /api/lists", apiCfg.getOwnListsHandler)
+	mux.HandleFunc("GET /api/lists/subscriptions", apiCfg.getSubscribedListsHandler)
+	mux.HandleFunc("GET /api/lists/{listID}", apiCfg.getListHandler)
+	mux.HandleFunc("PUT /api/lists/{listID}", apiCfg.updateListHandler)
+	mux.HandleFunc("DELETE /api/lists/{listID}", apiCfg.deleteListHandler)
+	mux.HandleFunc("GET /api/lists/{listID}/members", apiCfg.getListMembersHandler)
+	mux.HandleFunc("PUT /api/lists/{listID}/members/{userID}", apiCfg.addListMemberHandler)
+	mux.HandleFunc("DELETE /api/lists/{listID}/members/{userID}", apiCfg.removeListMemberHandler)
+	mux.HandleFunc("PUT /api/lists/{listID}/subscribe", apiCfg.subscribeListHandler)
+	mux.HandleFunc("DELETE /api/lists/{listID}/subscribe", apiCfg.unsubscribeListHandler)
+	mux.HandleFunc("GET /api/lists/{listID}/timeline", apiCfg.getListTimelineHandler)
+	mux.HandleFunc("GET /api/users/{userID}/lists", apiCfg.getUserListsHandler)
+	mux.HandleFunc("GET /api/notifications", apiCfg.getNotificationsHandler)
+	mux.HandleFunc("POST /api/notifications/read", apiCfg.markNotificationsReadHandler)
+	mux.HandleFunc("POST /api/notifications/{notificationID}/read", apiCfg.markNotificationReadHandler)
+	mux.HandleFunc("GET /api/notifications/preferences", apiCfg.getNotificationPreferencesHandler)
+	mux.HandleFunc("PUT /api/notifications/preferences", apiCfg.updateNotificationPreferencesHandler)
+	mux.HandleFunc("POST /api/media", apiCfg.uploadMediaHandler)
+	mux.HandleFunc("DELETE /api/media/{mediaID}", apiCfg.deleteMediaHandler)
+	mux.HandleFunc("GET /media/{key}", apiCfg.serveBlobHandler)
+	mux.HandleFunc("PUT /api/users/{userID}/block", apiCfg.blockHandler)
+	mux.HandleFunc("DELETE /api/users/{userID}/block", apiCfg.unblockHandler)
+	mux.HandleFunc("PUT /api/users/{userID}/mute", apiCfg.muteHandler)
+	mux.HandleFunc("DELETE /api/users/{userID}/mute", apiCfg.unmuteHandler)
+	mux.HandleFunc("GET /api/blocks", apiCfg.getBlocksHandler)
+	mux.HandleFunc("GET /api/mutes", apiCfg.getMutesHandler)
+	mux.HandleFunc("GET /api/users/{userID}/likes", apiCfg.getUserLikesHandler)
 	mux.HandleFunc("POST /api/users", apiCfg.addUserHandler)
+	mux.HandleFunc("GET /api/users/{handle}", apiCfg.getProfileHandler)
 	mux.HandleFunc("POST /api/login", apiCfg.verifyUserHandler)
 	mux.HandleFunc("PUT /api/users", apiCfg.updateUserHandler)
+	mux.HandleFunc("DELETE /api/users", apiCfg.deleteUserHandler)
+	mux.HandleFunc("GET /api/users/export", apiCfg.exportUserHandler)
 	mux.HandleFunc("POST /api/refresh", apiCfg.refreshHandler)
 	mux.HandleFunc("POST /api/revoke", apiCfg.revokeHandler)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteChirpHandler)
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.upgradeUserHandler)
 
+	go apiCfg.runAccountPurger(time.Minute)
+	go apiCfg.runAuditRetention(time.Hour)
+	go apiCfg.runMediaCleanup(time.Hour)
+	go apiCfg.runPollFinalizer(time.Minute)
+	go apiCfg.runScheduler(15*time.Second, time.Duration(envInt("SCHEDULE_MAX_DELAY_HOURS", 24))*time.Hour)
+
 	http.ListenAndServe(srv.Addr, srv.Handler)
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"internal/media"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// blobGracePeriod keeps a freshly written blob from being collected before
+// the upload that wrote it has been recorded.
+const blobGracePeriod = time.Hour
+
+func (cfg *apiConfig) uploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	tooLarge := fmt.Sprintf("Uploads are limited to %d bytes", cfg.mediaMaxBytes)
+
+	// leave room for the multipart headers around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.mediaMaxBytes+64*1024)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, 400, "Request must be multipart/form-data")
+		return
+	}
+
+	var data []byte
+	for data == nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			respondWithError(w, 400, `Missing "file" field`)
+			return
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, 413, tooLarge)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 400, "Malformed multipart body")
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		data, err = io.ReadAll(io.LimitReader(part, cfg.mediaMaxBytes+1))
+		if errors.As(err, &maxBytesErr) || int64(len(data)) > cfg.mediaMaxBytes {
+			respondWithError(w, 413, tooLarge)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 400, "Malformed multipart body")
+			return
+		}
+	}
+
+	img, err := media.Process(data, cfg.mediaLimits)
+	if errors.Is(err, media.ErrUnsupportedType) {
+		respondWithError(w, 415, err.Error())
+		return
+	}
+	if errors.Is(err, media.ErrInvalidImage) || errors.Is(err, media.ErrImageTooLarge) || errors.Is(err, media.ErrTooManyFrames) {
+		respondWithError(w, 422, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error processing image: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	blobKey, err := cfg.mediaStore.Put(img.Data)
+	if err != nil {
+		fmt.Printf("Error storing media: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	thumbnailKey, err := cfg.mediaStore.Put(img.Thumbnail)
+	if err != nil {
+		fmt.Printf("Error storing thumbnail: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	m, err := cfg.db.CreateMedia(database.Media{
+		OwnerID: userID,
+		ContentType: img.ContentType,
+		Width: img.Width,
+		Height: img.Height,
+		Size: int64(len(img.Data)),
+		URL: "/media/" + blobKey,
+		ThumbnailURL: "/media/" + thumbnailKey,
+		BlobKey: blobKey,
+		ThumbnailKey: thumbnailKey,
+		ThumbnailSize: int64(len(img.Thumbnail)),
+	}, cfg.mediaQuota)
+	if errors.Is(err, database.ErrMediaQuota) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(401)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error saving media: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(m)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(201)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) deleteMediaHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	mediaID, err := strconv.Atoi(r.PathValue("mediaID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	err = cfg.db.DeleteMedia(userID, mediaID)
+	if errors.Is(err, database.ErrMediaNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrMediaUnavailable) {
+		respondWithError(w, 409, "Media attached to a chirp or used as an avatar cannot be deleted")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error deleting media: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// serveBlobHandler serves stored media. Blobs are named by their contents,
+// so they never change and can be cached indefinitely.
+func (cfg *apiConfig) serveBlobHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := cfg.mediaStore.Open(r.PathValue("key"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Printf("Error reading media: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// only validated images are stored, so sniffing gives the right type
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		fmt.Printf("Error reading media: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// runMediaCleanup drops uploads that were never attached to a chirp, then
+// deletes blobs that no upload refers to any more.
+func (cfg *apiConfig) runMediaCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := cfg.db.PurgeOrphanMedia(time.Now().Add(-cfg.mediaOrphanAge))
+		if err != nil {
+			fmt.Printf("Error purging orphaned media: %s\n", err)
+			continue
+		}
+		if removed > 0 {
+			fmt.Printf("Purged %d orphaned uploads\n", removed)
+		}
+
+		referenced, err := cfg.db.GetReferencedBlobs()
+		if err != nil {
+			fmt.Printf("Error listing media references: %s\n", err)
+			continue
+		}
+		blobs, err := cfg.mediaStore.List()
+		if err != nil {
+			fmt.Printf("Error listing media blobs: %s\n", err)
+			continue
+		}
+		for _, blob := range blobs {
+			if referenced[blob.Key] || time.Since(blob.ModTime) < blobGracePeriod {
+				continue
+			}
+			if err := cfg.mediaStore.Delete(blob.Key); err != nil {
+				fmt.Printf("Error deleting blob %s: %s\n", blob.Key, err)
+			}
+		}
+	}
+}
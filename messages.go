@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type messagePage struct {
+	Messages []database.Message `json:"messages"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func (cfg *apiConfig) createConversationHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		MemberIDs []int `json:"member_ids"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid JSON body")
+		return
+	}
+
+	conversation, created, err := cfg.db.CreateConversation(userID, params.MemberIDs)
+	if errors.Is(err, database.ErrInvalidMembers) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(401)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error creating conversation: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// read positions are each member's own business
+	conversation.LastRead = nil
+
+	msg, err := json.Marshal(conversation)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if created {
+		w.WriteHeader(201)
+	} else {
+		w.WriteHeader(200)
+	}
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) getConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	conversations, err := cfg.db.GetConversations(userID)
+	if err != nil {
+		fmt.Printf("Error getting conversations: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(conversations)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) getMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	before, limit, err := parsePageParams(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	// fetch one extra to know whether another page exists
+	messages, err := cfg.db.GetMessages(userID, conversationID, before, limit+1)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error getting messages: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	page := messagePage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		page.NextCursor = encodeCursor(page.Messages[limit-1].ID)
+	}
+
+	msg, err := json.Marshal(page)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) sendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, 400, "Invalid JSON body")
+		return
+	}
+	if strings.TrimSpace(params.Body) == "" {
+		respondWithError(w, 400, "Message body is required")
+		return
+	}
+
+	body, err := cleanChirpBody(params.Body)
+	if err != nil {
+		respondWithError(w, 400, "Message is too long")
+		return
+	}
+
+	message, err := cfg.db.SendMessage(userID, conversationID, body)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error sending message: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(message)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(201)
+	w.Write(msg)
+}
+
+// markConversationReadHandler moves the caller's read position up to
+// message_id, or to the latest message when the body leaves it out.
+func (cfg *apiConfig) markConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	conversationID, err := strconv.Atoi(r.PathValue("conversationID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	type parameters struct {
+		MessageID int `json:"message_id"`
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.MessageID < 0 {
+			respondWithError(w, 400, "message_id must be the ID of a message")
+			return
+		}
+	}
+
+	err = cfg.db.MarkConversationRead(userID, conversationID, params.MessageID)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error marking conversation read: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
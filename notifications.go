@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+)
+
+type notificationPage struct {
+	Notifications []database.NotificationGroup `json:"notifications"`
+	UnreadCount int `json:"unread_count"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func (cfg *apiConfig) getNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	before, limit, err := parsePageParams(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	notifications, err := cfg.db.GetNotifications(userID, before, limit)
+	if err != nil {
+		fmt.Printf("Error getting notifications: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	page := notificationPage{
+		Notifications: notifications.Notifications,
+		UnreadCount: notifications.UnreadCount,
+	}
+	if notifications.NextCursor != 0 {
+		page.NextCursor = encodeCursor(notifications.NextCursor)
+	}
+
+	msg, err := json.Marshal(page)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+// markNotificationsReadHandler marks the inbox read up to the notification
+// up_to, or entirely when the body leaves it out.
+func (cfg *apiConfig) markNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type parameters struct {
+		UpTo int `json:"up_to"`
+	}
+
+	params := parameters{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.UpTo < 0 {
+			respondWithError(w, 400, "up_to must be the ID of a notification")
+			return
+		}
+	}
+
+	if err := cfg.db.MarkNotificationsRead(userID, params.UpTo); err != nil {
+		fmt.Printf("Error marking notifications read: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) markNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	notificationID, err := strconv.Atoi(r.PathValue("notificationID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	err = cfg.db.MarkNotificationGroupRead(userID, notificationID)
+	if errors.Is(err, database.ErrNotificationNotFound) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error marking notification read: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	prefs, err := cfg.db.GetNotificationPreferences(userID)
+	if err != nil {
+		fmt.Printf("Error getting notification preferences: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(prefs)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+// updateNotificationPreferencesHandler takes a map of notification type to
+// whether it is on. Types left out keep their current setting.
+func (cfg *apiConfig) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	changes := map[string]bool{}
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		respondWithError(w, 400, "Body must map notification types to true or false")
+		return
+	}
+
+	prefs, err := cfg.db.UpdateNotificationPreferences(userID, changes)
+	if errors.Is(err, database.ErrUnknownNotificationType) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(401)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error updating notification preferences: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(prefs)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	minPollDuration = 5 * time.Minute
+	maxPollDuration = 7 * 24 * time.Hour
+)
+
+var errPollDuration = fmt.Errorf("duration_minutes must be between %d and %d", int(minPollDuration.Minutes()), int(maxPollDuration.Minutes()))
+
+// pollParameters is the poll part of a new chirp request.
+type pollParameters struct {
+	Options []string `json:"options"`
+	DurationMinutes int `json:"duration_minutes"`
+}
+
+func (p pollParameters) options(now time.Time) (*database.PollOptions, error) {
+	duration := time.Duration(p.DurationMinutes) * time.Minute
+	if duration < minPollDuration || duration > maxPollDuration {
+		return nil, errPollDuration
+	}
+
+	return &database.PollOptions{
+		Options: p.Options,
+		ExpiresAt: now.Add(duration),
+	}, nil
+}
+
+func (cfg *apiConfig) votePollHandler(w http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get("Authorization")
+
+	bearerToken, err := auth.ParseBearerToken(header)
+	if err != nil {
+		fmt.Printf("Error parsing bearer token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	userID, err := auth.ParseUserIDFromJWT(bearerToken)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	type parameters struct {
+		Option *int `json:"option"`
+	}
+
+	params := parameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Option == nil {
+		respondWithError(w, 400, "option must be the index of a poll option")
+		return
+	}
+
+	poll, err := cfg.db.VotePoll(userID, chirpID, *params.Option)
+	if errors.Is(err, database.ErrChirpID) || errors.Is(err, database.ErrNoPoll) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, database.ErrUserNotFound) {
+		w.WriteHeader(401)
+		return
+	}
+	if errors.Is(err, database.ErrPollOption) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrBlocked) {
+		respondWithError(w, 403, err.Error())
+		return
+	}
+	if errors.Is(err, database.ErrPollClosed) || errors.Is(err, database.ErrAlreadyVoted) {
+		respondWithError(w, 409, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error recording vote: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	msg, err := json.Marshal(poll)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+func (cfg *apiConfig) runPollFinalizer(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		closed, err := cfg.db.FinalizePolls(time.Now())
+		if err != nil {
+			fmt.Printf("Error finalizing polls: %s\n", err)
+			continue
+		}
+		if len(closed) > 0 {
+			fmt.Printf("Closed %d polls\n", len(closed))
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/database"
+	"internal/search"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// searchDocument turns a chirp into something the index can hold. Rechirps
+// and tombstones have no text of their own and are left out, and only
+// public chirps are searchable.
+func searchDocument(chirp database.Chirp) (search.Document, bool) {
+	if chirp.RechirpOf != 0 || chirp.Deleted || chirp.Visibility != database.VisibilityPublic {
+		return search.Document{}, false
+	}
+
+	doc := search.Document{
+		ID: chirp.ID,
+		Body: chirp.Body,
+		AuthorID: chirp.AuthorID,
+		CreatedAt: chirp.CreatedAt,
+	}
+	if chirp.Original != nil {
+		doc.QuotedAuthorID = chirp.Original.AuthorID
+	}
+	return doc, true
+}
+
+// buildSearchIndex indexes every stored chirp and keeps the index in step
+// with later changes.
+func (cfg *apiConfig) buildSearchIndex() error {
+	cfg.searchIndex = search.NewIndex()
+
+	cfg.db.OnChirpEvent(func(event database.ChirpEvent) {
+		if event.Type == database.ChirpDeleted {
+			cfg.searchIndex.Remove(event.Chirp.ID)
+			return
+		}
+		if doc, ok := searchDocument(event.Chirp); ok {
+			cfg.searchIndex.Add(doc)
+		}
+	})
+
+	chirps, err := cfg.db.GetChirps(0, "asc", 0)
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		if doc, ok := searchDocument(chirp); ok {
+			cfg.searchIndex.Add(doc)
+		}
+	}
+
+	return nil
+}
+
+func (cfg *apiConfig) searchHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	query := r.URL.Query()
+
+	q, err := search.ParseQuery(query.Get("q"))
+	if errors.Is(err, search.ErrEmptyQuery) {
+		respondWithError(w, 400, "q must contain at least one word")
+		return
+	} else if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	opts := search.Options{Limit: 20}
+
+	intParams := map[string]*int{
+		"author_id": &opts.AuthorID,
+		"limit": &opts.Limit,
+		"offset": &opts.Offset,
+	}
+	for name, dest := range intParams {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondWithError(w, 400, fmt.Sprintf("%s must be a non-negative integer", name))
+			return
+		}
+		*dest = n
+	}
+	if opts.Limit == 0 || opts.Limit > 100 {
+		opts.Limit = 100
+	}
+
+	timeParams := map[string]*time.Time{
+		"since": &opts.Since,
+		"until": &opts.Until,
+	}
+	for name, dest := range timeParams {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, 400, fmt.Sprintf("%s must be an RFC 3339 timestamp", name))
+			return
+		}
+		*dest = t
+	}
+
+	opts.ExcludeAuthors, err = cfg.db.HiddenAuthors(viewerID)
+	if err != nil {
+		fmt.Printf("Error getting hidden authors: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	result := cfg.searchIndex.Search(q, opts)
+
+	ids := make([]int, len(result.Hits))
+	for i, hit := range result.Hits {
+		ids[i] = hit.ID
+	}
+
+	chirps, err := cfg.db.GetChirpsByIDs(ids, viewerID)
+	if err != nil {
+		fmt.Printf("Error getting chirps: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := cfg.db.SetViewerState(viewerID, chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type searchResponse struct {
+		Chirps []database.Chirp `json:"chirps"`
+		Total int `json:"total"`
+		Limit int `json:"limit"`
+		Offset int `json:"offset"`
+	}
+
+	msg, err := json.Marshal(searchResponse{
+		Chirps: chirps,
+		Total: result.Total,
+		Limit: opts.Limit,
+		Offset: opts.Offset,
+	})
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
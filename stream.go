@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/database"
+	"internal/entities"
+	"internal/stream"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	streamHeartbeat = 15 * time.Second
+	streamWriteTimeout = 10 * time.Second
+	streamRetry = 3 * time.Second
+)
+
+// streamFilter narrows a stream to some authors, a hashtag or the viewer's
+// home timeline. Set filters must all match.
+type streamFilter struct {
+	authorIDs map[int]bool
+	hashtag string
+	timeline bool
+}
+
+// buildStream starts relaying chirp creations, edits and deletions, and new
+// notifications, to stream and WebSocket subscribers. Notifications are
+// never replayed.
+func (cfg *apiConfig) buildStream(replaySize int, maxStreams int, bufferSize int) {
+	cfg.chirpStream = stream.NewHub(replaySize, maxStreams, bufferSize)
+	cfg.notificationStream = stream.NewHub(0, maxStreams, bufferSize)
+
+	cfg.db.OnChirpEvent(func(event database.ChirpEvent) {
+		switch event.Type {
+		case database.ChirpCreated, database.ChirpUpdated, database.ChirpDeleted:
+			cfg.chirpStream.Publish(event.Type, event.Chirp)
+		}
+	})
+	cfg.db.OnNotification(func(event database.NotificationEvent) {
+		cfg.notificationStream.Publish("notification", event)
+	})
+}
+
+func parseStreamFilter(r *http.Request, viewerID int) (streamFilter, map[string]string) {
+	query := r.URL.Query()
+	filter := streamFilter{hashtag: entities.NormalizeHashtag(query.Get("hashtag"))}
+	details := make(map[string]string)
+
+	for _, value := range query["author_id"] {
+		for _, part := range strings.Split(value, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || id < 0 {
+				details["author_id"] = "must be a comma separated list of user IDs"
+				break
+			}
+			if filter.authorIDs == nil {
+				filter.authorIDs = make(map[int]bool)
+			}
+			filter.authorIDs[id] = true
+		}
+	}
+
+	if value := query.Get("timeline"); value != "" {
+		timeline, err := strconv.ParseBool(value)
+		if err != nil {
+			details["timeline"] = "must be true or false"
+		} else if timeline && viewerID == 0 {
+			details["timeline"] = "requires signing in"
+		}
+		filter.timeline = timeline
+	}
+
+	return filter, details
+}
+
+// matches reports whether chirp belongs on a stream with this filter for
+// viewer. Unlisted chirps only show up when asked for by author or on the
+// home timeline, as in the listings.
+func (f streamFilter) matches(viewer database.Viewer, chirp database.Chirp) bool {
+	if !viewer.CanSee(chirp) {
+		return false
+	}
+	if f.authorIDs != nil && !f.authorIDs[chirp.AuthorID] {
+		return false
+	}
+	if f.timeline && chirp.AuthorID != viewer.ID && !viewer.Follows(chirp.AuthorID) {
+		return false
+	}
+	if f.hashtag != "" && !hasHashtag(chirp, f.hashtag) {
+		return false
+	}
+	if chirp.Visibility == database.VisibilityUnlisted && f.authorIDs == nil && !f.timeline {
+		return false
+	}
+	return true
+}
+
+func hasHashtag(chirp database.Chirp, tag string) bool {
+	for _, hashtag := range chirp.Entities.Hashtags {
+		if hashtag.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// lastEventID reads where a client left off, from the Last-Event-ID header
+// browsers send when reconnecting or from last_event_id for the first
+// connection.
+func lastEventID(r *http.Request) (uint64, bool, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, errors.New("Last-Event-ID must be an event ID")
+	}
+	return id, true, nil
+}
+
+// streamHandler serves chirp.created and chirp.deleted events as
+// Server-Sent Events. A client that reconnects with Last-Event-ID gets the
+// events it missed, or a resync event when they are no longer held.
+func (cfg *apiConfig) streamHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	filter, details := parseStreamFilter(r, viewerID)
+	if len(details) > 0 {
+		respondWithParamErrors(w, details)
+		return
+	}
+
+	lastID, resume, err := lastEventID(r)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	viewer, err := cfg.db.Viewer(viewerID)
+	if err != nil {
+		fmt.Printf("Error getting viewer: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	sub, missed, complete, err := cfg.chirpStream.Subscribe(lastID, resume)
+	if errors.Is(err, stream.ErrTooManySubscribers) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(streamRetry.Seconds())))
+		respondWithError(w, 503, "Too many open streams, try again later")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error opening stream: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	// send writes one block and flushes it. A client too slow to take it
+	// within the timeout is cut off, which also unblocks the hub.
+	send := func(block string) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprint(w, block); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	deliver := func(event stream.Event) bool {
+		chirp := event.Payload.(database.Chirp)
+		if event.Type == database.ChirpUpdated || !filter.matches(viewer, chirp) {
+			return true
+		}
+		block, err := sseEvent(event, viewer.View(chirp))
+		if err != nil {
+			fmt.Printf("Error marshalling JSON: %s", err)
+			return false
+		}
+		return send(block)
+	}
+
+	if !send(fmt.Sprintf("retry: %d\n\n", streamRetry.Milliseconds())) {
+		return
+	}
+	if !complete && !send("event: resync\ndata: {}\n\n") {
+		return
+	}
+	for _, event := range missed {
+		if !deliver(event) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind; the client reconnects and
+				// resumes from its last event
+				return
+			}
+			if !deliver(event) {
+				return
+			}
+		case <-heartbeat.C:
+			// follows and blocks may have changed since the last snapshot
+			if fresh, err := cfg.db.Viewer(viewerID); err == nil {
+				viewer = fresh
+			}
+			if !send(": heartbeat\n\n") {
+				return
+			}
+		}
+	}
+}
+
+// sseEvent formats a stream event. Deletions only carry the chirp's ID.
+func sseEvent(event stream.Event, chirp database.Chirp) (string, error) {
+	var data any = chirp
+	if event.Type == database.ChirpDeleted {
+		data = struct {
+			ID int `json:"id"`
+		}{ID: chirp.ID}
+	}
+
+	msg, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, msg), nil
+}
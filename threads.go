@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/database"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultThreadLimit = 20
+	maxThreadLimit = 100
+	defaultThreadDepth = 3
+	maxThreadDepth = 10
+	threadChildLimit = 5
+)
+
+func (cfg *apiConfig) getThreadHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	opts := database.ThreadOptions{
+		ViewerID: viewerID,
+		Limit: defaultThreadLimit,
+		Depth: defaultThreadDepth,
+		ChildLimit: threadChildLimit,
+	}
+
+	query := r.URL.Query()
+	params := []struct {
+		name string
+		dest *int
+		max int
+	}{
+		{"limit", &opts.Limit, maxThreadLimit},
+		{"depth", &opts.Depth, maxThreadDepth},
+		{"cursor", &opts.After, 0},
+	}
+	for _, param := range params {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || (param.max > 0 && n > param.max) {
+			respondWithError(w, 400, fmt.Sprintf("Invalid %s", param.name))
+			return
+		}
+		*param.dest = n
+	}
+	if opts.Limit == 0 {
+		opts.Limit = defaultThreadLimit
+	}
+
+	thread, err := cfg.db.GetThread(chirpID, opts)
+	if errors.Is(err, database.ErrChirpID) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error retrieving thread: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// viewer state is filled in on a flat copy and then put back in place
+	chirps := []database.Chirp{}
+	eachThreadChirp(&thread, func(chirp *database.Chirp) {
+		chirps = append(chirps, *chirp)
+	})
+	if err := cfg.db.SetViewerState(viewerID, chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	eachThreadChirp(&thread, func(chirp *database.Chirp) {
+		*chirp, chirps = chirps[0], chirps[1:]
+	})
+
+	msg, err := json.Marshal(thread)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
+
+// eachThreadChirp calls fn on every chirp in a thread the viewer can see:
+// the ancestors, the chirp itself and the reply tree, always in that order.
+func eachThreadChirp(thread *database.Thread, fn func(*database.Chirp)) {
+	for i := range thread.Ancestors {
+		if !thread.Ancestors[i].Unavailable {
+			fn(&thread.Ancestors[i])
+		}
+	}
+	fn(&thread.Chirp)
+
+	var walk func(nodes []database.ThreadNode)
+	walk = func(nodes []database.ThreadNode) {
+		for i := range nodes {
+			fn(&nodes[i].Chirp)
+			walk(nodes[i].Replies)
+		}
+	}
+	walk(thread.Replies)
+}
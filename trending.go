@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"internal/database"
+	"internal/trending"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const trendingBucketWidth = 5 * time.Minute
+
+type trendingWindow struct {
+	length time.Duration
+	halfLife time.Duration
+}
+
+// trendingWindows are the windows GET /api/trending accepts. The longest one
+// sets how much history the aggregators keep.
+var trendingWindows = map[string]trendingWindow{
+	"1h": {length: time.Hour, halfLife: 15 * time.Minute},
+	"24h": {length: 24 * time.Hour, halfLife: 6 * time.Hour},
+}
+
+const trendingSpan = 24 * time.Hour
+
+// buildTrending replays recent hashtag use and likes from the database into
+// fresh aggregators and keeps them fed from chirp events afterwards.
+func (cfg *apiConfig) buildTrending(maxKeys int) error {
+	cfg.trendingTags = trending.NewAggregator(trendingSpan, trendingBucketWidth, maxKeys)
+	cfg.trendingChirps = trending.NewAggregator(trendingSpan, trendingBucketWidth, maxKeys)
+
+	cfg.db.OnChirpEvent(func(event database.ChirpEvent) {
+		chirpKey := strconv.Itoa(event.Chirp.ID)
+		// only public chirps trend; visibility never changes, so the
+		// others never need removing either
+		if event.Chirp.Visibility != database.VisibilityPublic {
+			return
+		}
+		switch event.Type {
+		case database.ChirpCreated:
+			cfg.recordHashtags(event.Chirp, event.At, 1)
+		case database.ChirpLiked:
+			cfg.trendingChirps.Record(chirpKey, event.At, 1)
+		case database.ChirpUnliked:
+			cfg.trendingChirps.Record(chirpKey, event.At, -1)
+		case database.ChirpDeleted:
+			cfg.trendingChirps.Remove(chirpKey)
+			// a tombstone being cleared up had its tags taken off when it
+			// was first deleted
+			if !event.Chirp.Deleted {
+				cfg.recordHashtags(event.Chirp, event.Chirp.CreatedAt, -1)
+			}
+		}
+	})
+
+	since := time.Now().Add(-trendingSpan)
+
+	// an anonymous listing holds exactly the public chirps
+	chirps, err := cfg.db.GetChirps(0, "asc", 0)
+	if err != nil {
+		return err
+	}
+	public := make(map[int]bool)
+	for _, chirp := range chirps {
+		public[chirp.ID] = true
+		if chirp.CreatedAt.After(since) {
+			cfg.recordHashtags(chirp, chirp.CreatedAt, 1)
+		}
+	}
+
+	likes, err := cfg.db.GetLikesSince(since)
+	if err != nil {
+		return err
+	}
+	for _, like := range likes {
+		if !public[like.ChirpID] {
+			continue
+		}
+		cfg.trendingChirps.Record(strconv.Itoa(like.ChirpID), like.LikedAt, 1)
+	}
+
+	return nil
+}
+
+// recordHashtags counts each tag once per chirp, however often it repeats.
+// A weight of -1 takes a deleted chirp's tags back off.
+func (cfg *apiConfig) recordHashtags(chirp database.Chirp, at time.Time, weight float64) {
+	seen := make(map[string]bool)
+	for _, hashtag := range chirp.Entities.Hashtags {
+		if !seen[hashtag.Tag] {
+			seen[hashtag.Tag] = true
+			cfg.trendingTags.Record(hashtag.Tag, at, weight)
+		}
+	}
+}
+
+func (cfg *apiConfig) getTrendingHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID, err := viewerIDFromRequest(r)
+	if err != nil {
+		fmt.Printf("Error validating jwt token: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	windowName := r.URL.Query().Get("window")
+	if windowName == "" {
+		windowName = "24h"
+	}
+	window, ok := trendingWindows[windowName]
+	if !ok {
+		respondWithError(w, 400, `window must be "1h" or "24h"`)
+		return
+	}
+
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 50 {
+			respondWithError(w, 400, "limit must be between 1 and 50")
+			return
+		}
+	}
+
+	type trendingHashtag struct {
+		Tag string `json:"tag"`
+		Score float64 `json:"score"`
+		Count int `json:"count"`
+	}
+	type trendingChirp struct {
+		Chirp database.Chirp `json:"chirp"`
+		Score float64 `json:"score"`
+		Count int `json:"count"`
+	}
+	type trendingResponse struct {
+		Window string `json:"window"`
+		Hashtags []trendingHashtag `json:"hashtags"`
+		Chirps []trendingChirp `json:"chirps"`
+	}
+
+	resp := trendingResponse{
+		Window: windowName,
+		Hashtags: []trendingHashtag{},
+		Chirps: []trendingChirp{},
+	}
+
+	for _, trend := range cfg.trendingTags.Top(window.length, window.halfLife, limit) {
+		resp.Hashtags = append(resp.Hashtags, trendingHashtag{Tag: trend.Key, Score: trend.Score, Count: int(trend.Count)})
+	}
+
+	// rank everything, since chirps the viewer may not see are dropped below
+	trends := cfg.trendingChirps.Top(window.length, window.halfLife, 0)
+	byID := make(map[int]trending.Trend)
+	ids := make([]int, 0, len(trends))
+	for _, trend := range trends {
+		id, err := strconv.Atoi(trend.Key)
+		if err != nil {
+			continue
+		}
+		byID[id] = trend
+		ids = append(ids, id)
+	}
+
+	chirps, err := cfg.db.GetChirpsByIDs(ids, viewerID)
+	if err != nil {
+		fmt.Printf("Error getting chirps: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+	}
+
+	if err := cfg.db.SetViewerState(viewerID, chirps); err != nil {
+		fmt.Printf("Error getting viewer state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	for _, chirp := range chirps {
+		trend := byID[chirp.ID]
+		resp.Chirps = append(resp.Chirps, trendingChirp{Chirp: chirp, Score: trend.Score, Count: int(trend.Count)})
+	}
+
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(msg)
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"internal/auth"
+	"internal/database"
+	"internal/stream"
+	"internal/websocket"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	wsAuthTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsReadTimeout = 75 * time.Second
+	wsReauthWarning = time.Minute
+	wsMaxThreads = 10
+)
+
+// Application close codes, in the range RFC 6455 leaves to applications.
+const (
+	closeTokenExpired = 4001
+	closeFellBehind = 4002
+	closeAuthFailed = 4003
+)
+
+type wsClientMessage struct {
+	Type string `json:"type"`
+	Token string `json:"token"`
+	Channel string `json:"channel"`
+}
+
+type wsServerMessage struct {
+	Type string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Event string `json:"event,omitempty"`
+	Data any `json:"data,omitempty"`
+	UserID int `json:"user_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// wsSession is one authenticated connection and what it is subscribed to.
+// Only the goroutine running the session writes to it.
+type wsSession struct {
+	cfg *apiConfig
+	conn *websocket.Conn
+	identity auth.Identity
+	viewer database.Viewer
+
+	timeline bool
+	// threads maps each followed thread to the IDs of the chirps in it
+	threads map[int]map[int]bool
+	chirpSub *stream.Subscription
+	notificationSub *stream.Subscription
+
+	expiry *time.Timer
+	warned bool
+}
+
+// websocketHandler serves GET /api/ws, a WebSocket carrying live updates
+// as JSON text messages.
+//
+// Authenticate either with an Authorization: Bearer header on the upgrade
+// request or, for clients that can't set headers, by sending
+//
+//	{"type": "auth", "token": "<access token>"}
+//
+// within 10 seconds of connecting. The server answers
+//
+//	{"type": "authenticated", "user_id": 1, "expires_at": "..."}
+//
+// A minute before the access token expires the server sends
+//
+//	{"type": "reauth_required", "expires_at": "..."}
+//
+// and the client should send a fresh token for the same user in another
+// auth message. Otherwise the connection is closed with code 4001 once the
+// token expires. A token for a different user closes it with 4003.
+//
+// Channels are joined and left with
+//
+//	{"type": "subscribe", "channel": "timeline"}
+//	{"type": "unsubscribe", "channel": "timeline"}
+//
+// which are confirmed with "subscribed" and "unsubscribed" messages naming
+// the channel. The channels are:
+//
+//	timeline       chirps from you and the accounts you follow
+//	notifications  new entries in your notifications inbox
+//	thread:<id>    the chirp with that ID and every reply beneath it
+//
+// Updates arrive as
+//
+//	{"type": "event", "channel": "timeline", "event": "chirp.created", "data": {...}}
+//
+// where event is chirp.created, chirp.updated or chirp.deleted with the
+// chirp as data (deletions only carry its id), or notification with the
+// inbox entry as data. Requests that can't be honored get
+//
+//	{"type": "error", "channel": "...", "error": "..."}
+//
+// The server pings every 30 seconds and drops connections that have sent
+// nothing, pongs included, for 75. A connection that can't keep up with its
+// updates is closed with code 4002 and should reconnect and refetch.
+func (cfg *apiConfig) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	var identity auth.Identity
+	authenticated := false
+	if header := r.Header.Get("Authorization"); header != "" {
+		bearerToken, err := auth.ParseBearerToken(header)
+		if err == nil {
+			identity, err = auth.ParseIdentityFromJWT(bearerToken)
+		}
+		if err != nil {
+			fmt.Printf("Error validating jwt token: %s", err)
+			w.WriteHeader(401)
+			return
+		}
+		authenticated = true
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if errors.Is(err, websocket.ErrBadHandshake) {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error upgrading connection: %s", err)
+		return
+	}
+	conn.ReadTimeout = wsReadTimeout
+	defer conn.Close(websocket.CloseGoingAway, "")
+
+	// the reader hands messages over until the session ends
+	messages := make(chan []byte)
+	readErrs := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				readErrs <- err
+				return
+			}
+			select {
+			case messages <- msg:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if !authenticated {
+		identity, err = wsAwaitAuth(messages, readErrs)
+		if err != nil {
+			conn.Close(closeAuthFailed, err.Error())
+			return
+		}
+	}
+
+	s := &wsSession{cfg: cfg, conn: conn, threads: make(map[int]map[int]bool)}
+	defer s.unsubscribeAll()
+	if !s.authenticate(identity) {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		var chirpEvents, notificationEvents <-chan stream.Event
+		if s.chirpSub != nil {
+			chirpEvents = s.chirpSub.C
+		}
+		if s.notificationSub != nil {
+			notificationEvents = s.notificationSub.C
+		}
+
+		select {
+		case <-readErrs:
+			return
+		case msg := <-messages:
+			if !s.handleMessage(msg) {
+				return
+			}
+		case event, ok := <-chirpEvents:
+			if !ok {
+				conn.Close(closeFellBehind, "Fell behind")
+				return
+			}
+			if !s.deliverChirp(event) {
+				return
+			}
+		case event, ok := <-notificationEvents:
+			if !ok {
+				conn.Close(closeFellBehind, "Fell behind")
+				return
+			}
+			n := event.Payload.(database.NotificationEvent)
+			if n.UserID == s.identity.UserID && !s.send(wsServerMessage{Type: "event", Channel: "notifications", Event: event.Type, Data: n.Notification}) {
+				return
+			}
+		case <-s.expiryC():
+			if s.warned {
+				conn.Close(closeTokenExpired, "Token expired")
+				return
+			}
+			s.warned = true
+			s.expiry.Reset(time.Until(s.identity.ExpiresAt))
+			if !s.send(wsServerMessage{Type: "reauth_required", ExpiresAt: &s.identity.ExpiresAt}) {
+				return
+			}
+		case <-ping.C:
+			if err := conn.Ping(); err != nil {
+				return
+			}
+			// follows and blocks may have changed since the last snapshot
+			if viewer, err := cfg.db.Viewer(s.identity.UserID); err == nil {
+				s.viewer = viewer
+			}
+		}
+	}
+}
+
+// wsAwaitAuth waits for the auth message a client without an Authorization
+// header has to send first.
+func wsAwaitAuth(messages <-chan []byte, readErrs <-chan error) (auth.Identity, error) {
+	select {
+	case msg := <-messages:
+		params := wsClientMessage{}
+		if err := json.Unmarshal(msg, &params); err != nil || params.Type != "auth" {
+			return auth.Identity{}, errors.New("Expected an auth message")
+		}
+		identity, err := auth.ParseIdentityFromJWT(params.Token)
+		if err != nil {
+			return auth.Identity{}, errors.New("Invalid token")
+		}
+		return identity, nil
+	case err := <-readErrs:
+		return auth.Identity{}, err
+	case <-time.After(wsAuthTimeout):
+		return auth.Identity{}, errors.New("Timed out waiting for auth")
+	}
+}
+
+// authenticate adopts a validated identity, restarts the expiry clock and
+// tells the client.
+func (s *wsSession) authenticate(identity auth.Identity) bool {
+	viewer, err := s.cfg.db.Viewer(identity.UserID)
+	if err != nil {
+		fmt.Printf("Error getting viewer: %s", err)
+		s.conn.Close(websocket.CloseInternalError, "")
+		return false
+	}
+	s.identity = identity
+	s.viewer = viewer
+
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	s.warned = false
+	msg := wsServerMessage{Type: "authenticated", UserID: identity.UserID}
+	if !identity.ExpiresAt.IsZero() {
+		s.expiry = time.NewTimer(time.Until(identity.ExpiresAt.Add(-wsReauthWarning)))
+		msg.ExpiresAt = &identity.ExpiresAt
+	}
+
+	return s.send(msg)
+}
+
+// expiryC is nil, and so never fires, for tokens without an expiry.
+func (s *wsSession) expiryC() <-chan time.Time {
+	if s.expiry == nil {
+		return nil
+	}
+	return s.expiry.C
+}
+
+func (s *wsSession) send(msg wsServerMessage) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Printf("Error marshalling JSON: %s", err)
+		return false
+	}
+	return s.conn.WriteText(data) == nil
+}
+
+func (s *wsSession) sendError(channel string, message string) bool {
+	return s.send(wsServerMessage{Type: "error", Channel: channel, Error: message})
+}
+
+// handleMessage acts on one client message. It returns false once the
+// connection should end.
+func (s *wsSession) handleMessage(msg []byte) bool {
+	params := wsClientMessage{}
+	if err := json.Unmarshal(msg, &params); err != nil {
+		return s.sendError("", "Invalid JSON message")
+	}
+
+	switch params.Type {
+	case "auth":
+		identity, err := auth.ParseIdentityFromJWT(params.Token)
+		if err != nil {
+			return s.sendError("", "Invalid token")
+		}
+		if identity.UserID != s.identity.UserID {
+			s.conn.Close(closeAuthFailed, "Token is for a different user")
+			return false
+		}
+		return s.authenticate(identity)
+	case "subscribe":
+		if err := s.subscribe(params.Channel); err != nil {
+			return s.sendError(params.Channel, err.Error())
+		}
+		return s.send(wsServerMessage{Type: "subscribed", Channel: params.Channel})
+	case "unsubscribe":
+		if err := s.unsubscribe(params.Channel); err != nil {
+			return s.sendError(params.Channel, err.Error())
+		}
+		return s.send(wsServerMessage{Type: "unsubscribed", Channel: params.Channel})
+	}
+	return s.sendError("", "Unknown message type")
+}
+
+// parseThreadChannel returns the chirp ID of a thread:<id> channel.
+func parseThreadChannel(channel string) (int, bool) {
+	idString, ok := strings.CutPrefix(channel, "thread:")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(idString)
+	return id, err == nil && id > 0
+}
+
+func (s *wsSession) subscribe(channel string) error {
+	switch channel {
+	case "timeline":
+		if err := s.openChirpSub(); err != nil {
+			return err
+		}
+		s.timeline = true
+		return nil
+	case "notifications":
+		if s.notificationSub != nil {
+			return nil
+		}
+		sub, _, _, err := s.cfg.notificationStream.Subscribe(0, false)
+		if err != nil {
+			return wsSubscribeError(err)
+		}
+		s.notificationSub = sub
+		return nil
+	}
+
+	chirpID, ok := parseThreadChannel(channel)
+	if !ok {
+		return errors.New("Unknown channel")
+	}
+	if _, ok := s.threads[chirpID]; ok {
+		return nil
+	}
+	if len(s.threads) >= wsMaxThreads {
+		return fmt.Errorf("At most %d threads can be followed at once", wsMaxThreads)
+	}
+
+	ids, err := s.cfg.db.ThreadChirpIDs(chirpID, s.identity.UserID)
+	if errors.Is(err, database.ErrChirpID) {
+		return errors.New("Chirp not found")
+	}
+	if err != nil {
+		fmt.Printf("Error getting thread: %s", err)
+		return errors.New("Could not load thread")
+	}
+	if err := s.openChirpSub(); err != nil {
+		return err
+	}
+	s.threads[chirpID] = ids
+	return nil
+}
+
+func (s *wsSession) unsubscribe(channel string) error {
+	switch channel {
+	case "timeline":
+		s.timeline = false
+	case "notifications":
+		if s.notificationSub != nil {
+			s.notificationSub.Close()
+			s.notificationSub = nil
+		}
+		return nil
+	default:
+		chirpID, ok := parseThreadChannel(channel)
+		if !ok {
+			return errors.New("Unknown channel")
+		}
+		delete(s.threads, chirpID)
+	}
+
+	if !s.timeline && len(s.threads) == 0 && s.chirpSub != nil {
+		s.chirpSub.Close()
+		s.chirpSub = nil
+	}
+	return nil
+}
+
+// openChirpSub joins the chirp hub, shared by the timeline and every
+// thread, if the session hasn't already.
+func (s *wsSession) openChirpSub() error {
+	if s.chirpSub != nil {
+		return nil
+	}
+	sub, _, _, err := s.cfg.chirpStream.Subscribe(0, false)
+	if err != nil {
+		return wsSubscribeError(err)
+	}
+	s.chirpSub = sub
+	return nil
+}
+
+func wsSubscribeError(err error) error {
+	if errors.Is(err, stream.ErrTooManySubscribers) {
+		return errors.New("Too many open streams, try again later")
+	}
+	fmt.Printf("Error opening stream: %s", err)
+	return errors.New("Could not subscribe")
+}
+
+func (s *wsSession) unsubscribeAll() {
+	if s.chirpSub != nil {
+		s.chirpSub.Close()
+	}
+	if s.notificationSub != nil {
+		s.notificationSub.Close()
+	}
+}
+
+// deliverChirp sends a chirp event to the timeline and to each thread it
+// belongs to. New replies join their thread.
+func (s *wsSession) deliverChirp(event stream.Event) bool {
+	chirp := event.Payload.(database.Chirp)
+	if !s.viewer.CanSee(chirp) {
+		return true
+	}
+
+	var data any = s.viewer.View(chirp)
+	if event.Type == database.ChirpDeleted {
+		data = struct {
+			ID int `json:"id"`
+		}{ID: chirp.ID}
+	}
+
+	if s.timeline && (streamFilter{timeline: true}).matches(s.viewer, chirp) {
+		if !s.send(wsServerMessage{Type: "event", Channel: "timeline", Event: event.Type, Data: data}) {
+			return false
+		}
+	}
+
+	for rootID, ids := range s.threads {
+		if event.Type == database.ChirpCreated && chirp.InReplyTo != 0 && ids[chirp.InReplyTo] {
+			ids[chirp.ID] = true
+		}
+		if !ids[chirp.ID] {
+			continue
+		}
+		channel := "thread:" + strconv.Itoa(rootID)
+		if !s.send(wsServerMessage{Type: "event", Channel: channel, Event: event.Type, Data: data}) {
+			return false
+		}
+	}
+
+	return true
+}